@@ -0,0 +1,355 @@
+package herald
+
+import (
+	"errors"
+	"math"
+	"net"
+	"net/http"
+	"time"
+)
+
+const (
+
+	// abusePruneInterval specifies how often scores that are no longer
+	// relevant are removed.
+	abusePruneInterval = time.Minute
+
+	// abuseExpiry specifies how long a score that has not changed is kept,
+	// even if it has not decayed.
+	abuseExpiry = time.Hour
+)
+
+// CloseBanned is the close code sent to clients that are disconnected because
+// their abuse score reached AbusePolicy.BanScore.
+const CloseBanned = 4403
+
+var (
+	// ErrBanned indicates that a connection was rejected or a client was
+	// disconnected because its abuse score reached AbusePolicy.BanScore.
+	ErrBanned = errors.New("client banned")
+)
+
+// AbuseKind identifies a kind of misbehaviour that adds to an abuse score.
+type AbuseKind int
+
+const (
+
+	// AbuseRateLimited indicates that a request was rejected by AcceptRate
+	// or by the rate limit of a PublishHandler. The package does not limit
+	// the rate of messages from connected clients, so applications that do
+	// report it with ReportAbuse().
+	AbuseRateLimited AbuseKind = iota
+
+	// AbuseInvalidMessage indicates that a client sent a message that could
+	// not be decoded or an invalid handshake.
+	AbuseInvalidMessage
+
+	// AbuseAuthFailure indicates that a connection was rejected by a
+	// function registered with UseConnect(), that a client failed to
+	// re-authenticate, or that a client was not allowed to subscribe to a
+	// State.
+	AbuseAuthFailure
+)
+
+// String returns a human-readable name for the kind.
+func (k AbuseKind) String() string {
+	switch k {
+	case AbuseRateLimited:
+		return "rate_limited"
+	case AbuseInvalidMessage:
+		return "invalid_message"
+	case AbuseAuthFailure:
+		return "auth_failure"
+	default:
+		return "unknown"
+	}
+}
+
+// AbuseScore is the abuse score of a single key.
+type AbuseScore struct {
+
+	// Score is the score at the time it was last updated.
+	Score float64
+
+	// Updated is the time at which the score was last updated.
+	Updated time.Time
+
+	// BannedUntil is the time at which the ban on the key ends or the zero
+	// value if it is not banned.
+	BannedUntil time.Time
+}
+
+// AbusePolicy combines misbehaviour reported for clients into a score for
+// each IP address, or other key, and restricts clients as the score rises.
+// Each threshold applies once the score reaches it; a threshold of zero is
+// disabled.
+type AbusePolicy struct {
+
+	// Key returns the key used to score the request. If nil, the IP address
+	// from the request's RemoteAddr is used.
+	Key func(r *http.Request) string
+
+	// Weights specifies how much each kind of misbehaviour adds to the
+	// score. Kinds that are not present add one.
+	Weights map[AbuseKind]float64
+
+	// HalfLife specifies how long it takes for a score to decay to half of
+	// its value. A value of zero prevents scores from decaying. In either
+	// case, scores that have not changed for an hour are forgotten unless
+	// the key is banned.
+	HalfLife time.Duration
+
+	// ThrottleScore is the score at which Throttle is applied to the client
+	// for the rest of its connection.
+	ThrottleScore float64
+	Throttle      *Throttle
+
+	// QuarantineScore is the score at which messages from the client are
+	// discarded without being processed for the rest of its connection.
+	QuarantineScore float64
+
+	// BanScore is the score at which the client is disconnected with
+	// CloseBanned. New connections with the same key are rejected with 403
+	// Forbidden for BanDuration.
+	BanScore    float64
+	BanDuration time.Duration
+
+	// LoadScore returns the saved score for the key or nil if there is none.
+	// It is invoked the first time a key is seen, allowing scores to be
+	// shared between instances and to survive restarts. Keys without a saved
+	// score are not looked up again for a minute. This field is optional.
+	LoadScore func(key string) *AbuseScore
+
+	// SaveScore is invoked each time the score for a key changes. This field
+	// is optional.
+	SaveScore func(key string, score *AbuseScore)
+}
+
+// key returns the key used to score the request.
+func (p *AbusePolicy) key(r *http.Request) string {
+	if p.Key != nil {
+		return p.Key(r)
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// decay returns the score as of the specified time.
+func (p *AbusePolicy) decay(s *AbuseScore, now time.Time) float64 {
+	if p.HalfLife <= 0 || s.Score == 0 {
+		return s.Score
+	}
+	return s.Score * math.Pow(0.5, float64(now.Sub(s.Updated))/float64(p.HalfLife))
+}
+
+// loadAbuseScore invokes LoadScore for the key if its score is not already
+// known. LoadScore is invoked without holding the abuse mutex since it may
+// perform I/O, and keys without a saved score are remembered for
+// abusePruneInterval so that it is not invoked for every request.
+func (h *Herald) loadAbuseScore(key string) {
+	fn := h.AbusePolicy.LoadScore
+	if fn == nil {
+		return
+	}
+	now := h.clock().Now()
+	h.abuseMutex.Lock()
+	h.pruneAbuseScores(now)
+	_, ok := h.abuseScores[key]
+	_, missed := h.abuseMisses[key]
+	h.abuseMutex.Unlock()
+	if ok || missed {
+		return
+	}
+	v := fn(key)
+	h.abuseMutex.Lock()
+	defer h.abuseMutex.Unlock()
+	if _, ok := h.abuseScores[key]; ok {
+		return
+	}
+	if v == nil {
+		if h.abuseMisses == nil {
+			h.abuseMisses = make(map[string]time.Time)
+		}
+		h.abuseMisses[key] = now
+		return
+	}
+	s := *v
+	h.storeAbuseScore(key, &s)
+}
+
+// storeAbuseScore records the score for the key. The abuse mutex must be
+// held.
+func (h *Herald) storeAbuseScore(key string, s *AbuseScore) {
+	if h.abuseScores == nil {
+		h.abuseScores = make(map[string]*AbuseScore)
+	}
+	h.abuseScores[key] = s
+	delete(h.abuseMisses, key)
+}
+
+// abuseScore returns the score for the key. If the key has no score, nil is
+// returned unless create is true. The abuse mutex must be held.
+func (h *Herald) abuseScore(key string, create bool) *AbuseScore {
+	if s, ok := h.abuseScores[key]; ok || !create {
+		return s
+	}
+	s := &AbuseScore{}
+	h.storeAbuseScore(key, s)
+	return s
+}
+
+// pruneAbuseScores removes the scores of keys that are not banned and whose
+// scores have either decayed to almost nothing or expired, along with keys
+// that were found to have no saved score more than abusePruneInterval ago,
+// at most once per abusePruneInterval. The abuse mutex must be held.
+func (h *Herald) pruneAbuseScores(now time.Time) {
+	if now.Sub(h.abusePruned) < abusePruneInterval {
+		return
+	}
+	h.abusePruned = now
+	for k, t := range h.abuseMisses {
+		if now.Sub(t) >= abusePruneInterval {
+			delete(h.abuseMisses, k)
+		}
+	}
+	p := h.AbusePolicy
+	for k, s := range h.abuseScores {
+		if now.Before(s.BannedUntil) {
+			continue
+		}
+		if p.decay(s, now) < 0.01 || now.Sub(s.Updated) >= abuseExpiry {
+			delete(h.abuseScores, k)
+		}
+	}
+}
+
+// addAbuse adds the weight of the misbehaviour to the score for the key and
+// returns the new score.
+func (h *Herald) addAbuse(key string, kind AbuseKind) float64 {
+	p := h.AbusePolicy
+	w, ok := p.Weights[kind]
+	if !ok {
+		w = 1
+	}
+	h.loadAbuseScore(key)
+	now := h.clock().Now()
+	h.abuseMutex.Lock()
+	h.pruneAbuseScores(now)
+	s := h.abuseScore(key, true)
+	s.Score = p.decay(s, now) + w
+	s.Updated = now
+	if p.BanScore > 0 && s.Score >= p.BanScore && p.BanDuration > 0 {
+		s.BannedUntil = now.Add(p.BanDuration)
+	}
+	v := *s
+	h.abuseMutex.Unlock()
+	if p.SaveScore != nil {
+		p.SaveScore(key, &v)
+	}
+	return v.Score
+}
+
+// checkBanned rejects the request if its key is banned.
+func (h *Herald) checkBanned(w http.ResponseWriter, r *http.Request) error {
+	p := h.AbusePolicy
+	if p == nil {
+		return nil
+	}
+	key := p.key(r)
+	h.loadAbuseScore(key)
+	h.abuseMutex.Lock()
+	s := h.abuseScore(key, false)
+	banned := s != nil && h.clock().Now().Before(s.BannedUntil)
+	h.abuseMutex.Unlock()
+	if banned {
+		h.reject(w, r, http.StatusForbidden, ErrBanned)
+		return ErrBanned
+	}
+	return nil
+}
+
+// reportRequestAbuse adds to the score of a request that did not result in a
+// client.
+func (h *Herald) reportRequestAbuse(r *http.Request, kind AbuseKind) {
+	if p := h.AbusePolicy; p != nil {
+		h.addAbuse(p.key(r), kind)
+	}
+}
+
+// initAbuse records the key for a new client and applies the restrictions
+// for its current score.
+func (h *Herald) initAbuse(c *Client, r *http.Request) {
+	p := h.AbusePolicy
+	if p == nil {
+		return
+	}
+	c.abuseKey = p.key(r)
+	h.loadAbuseScore(c.abuseKey)
+	var score float64
+	h.abuseMutex.Lock()
+	if s := h.abuseScore(c.abuseKey, false); s != nil {
+		score = p.decay(s, h.clock().Now())
+	}
+	h.abuseMutex.Unlock()
+	if score > 0 {
+		c.restrict(score)
+	}
+}
+
+// restrict applies the restrictions for the score to the client.
+func (c *Client) restrict(score float64) {
+	p := c.herald.AbusePolicy
+	if p.BanScore > 0 && score >= p.BanScore {
+		c.closeWithCode(CloseBanned, ErrBanned.Error())
+		return
+	}
+	c.mutex.Lock()
+	throttle := p.ThrottleScore > 0 && score >= p.ThrottleScore && !c.abuseThrottled
+	if throttle {
+		c.abuseThrottled = true
+	}
+	if p.QuarantineScore > 0 && score >= p.QuarantineScore {
+		c.quarantined = true
+	}
+	c.mutex.Unlock()
+	if throttle {
+		c.SetThrottle(p.Throttle)
+	}
+}
+
+// ReportAbuse adds the misbehaviour to the abuse score of the client's key and
+// restricts the client according to AbusePolicy. Applications can use it to
+// report misbehaviour the package cannot detect, such as exceeding their own
+// rate limits. It has no effect if AbusePolicy is nil.
+func (h *Herald) ReportAbuse(c *Client, kind AbuseKind) {
+	if h.AbusePolicy == nil {
+		return
+	}
+	c.restrict(h.addAbuse(c.abuseKey, kind))
+}
+
+// AbuseScore returns the current abuse score for the key.
+func (h *Herald) AbuseScore(key string) float64 {
+	p := h.AbusePolicy
+	if p == nil {
+		return 0
+	}
+	h.abuseMutex.Lock()
+	defer h.abuseMutex.Unlock()
+	s, ok := h.abuseScores[key]
+	if !ok {
+		return 0
+	}
+	return p.decay(s, h.clock().Now())
+}
+
+// Quarantined returns true if the client's messages are being discarded
+// because its abuse score reached AbusePolicy.QuarantineScore.
+func (c *Client) Quarantined() bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.quarantined
+}
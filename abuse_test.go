@@ -0,0 +1,159 @@
+package herald
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestHeraldAbusePolicy(t *testing.T) {
+
+	// Create the server with a policy that quarantines clients after two
+	// invalid messages and bans them after three
+	var (
+		saved = make(chan float64, 3)
+		s     = newTestServer(func(h *Herald) {
+			h.AbusePolicy = &AbusePolicy{
+				QuarantineScore: 2,
+				BanScore:        3,
+				BanDuration:     time.Minute,
+				SaveScore: func(key string, score *AbuseScore) {
+					saved <- score.Score
+				},
+			}
+		})
+	)
+	defer s.herald.Close()
+	c := newTestClient(t, s)
+
+	// Utility function for sending an invalid message
+	sendInvalid := func() {
+		if err := c.conn.WriteMessage(websocket.TextMessage, []byte("x")); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Send two invalid messages and ensure the client is quarantined
+	for i := 0; i < 2; i++ {
+		sendInvalid()
+		c.receive(t, s, &Message{Type: ErrorMessageType})
+	}
+	if !c.client.Quarantined() {
+		t.Fatal("client not quarantined")
+	}
+
+	// Send another and ensure the client is banned
+	s.clientRemovedWG.Add(1)
+	sendInvalid()
+	c.verifyDisconnected(t)
+	s.clientRemovedWG.Wait()
+	for i := 1; i <= 3; i++ {
+		if v := <-saved; v != float64(i) {
+			t.Fatalf("%v != %d", v, i)
+		}
+	}
+
+	// Ensure that a new connection from the same address is rejected
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.herald.AddClient(w, r, clientData)
+	}))
+	defer server.Close()
+	_, resp, err := websocket.DefaultDialer.Dial(strings.Replace(server.URL, "http", "ws", 1), nil)
+	if err == nil {
+		t.Fatal("error expected")
+	}
+	if resp.StatusCode != http.StatusForbidden {
+		t.Fatalf("%d != %d", resp.StatusCode, http.StatusForbidden)
+	}
+	if v := s.herald.UpgradeFailures()[UpgradeBanned]; v != 1 {
+		t.Fatalf("%d != 1", v)
+	}
+}
+
+func TestHeraldAbuseDecay(t *testing.T) {
+
+	// Add to a score and ensure that it halves after each half-life
+	clock := newFakeClock()
+	h := New()
+	h.Clock = clock
+	h.AbusePolicy = &AbusePolicy{
+		Weights:  map[AbuseKind]float64{AbuseRateLimited: 4},
+		HalfLife: time.Minute,
+	}
+	h.addAbuse("key", AbuseRateLimited)
+	clock.Advance(time.Minute)
+	if v := h.AbuseScore("key"); v != 2 {
+		t.Fatalf("%v != 2", v)
+	}
+	clock.Advance(time.Minute)
+	if v := h.addAbuse("key", AbuseRateLimited); v != 5 {
+		t.Fatalf("%v != 5", v)
+	}
+}
+
+func TestHeraldAbusePrune(t *testing.T) {
+
+	// Score a key with a policy whose scores never decay
+	clock := newFakeClock()
+	h := New()
+	h.Clock = clock
+	h.AbusePolicy = &AbusePolicy{}
+	h.addAbuse("a", AbuseRateLimited)
+
+	// Ensure that the score is kept for a while but forgotten once it
+	// expires
+	clock.Advance(abusePruneInterval)
+	h.addAbuse("b", AbuseRateLimited)
+	if v := h.AbuseScore("a"); v != 1 {
+		t.Fatalf("%v != 1", v)
+	}
+	clock.Advance(abuseExpiry)
+	h.addAbuse("b", AbuseRateLimited)
+	if v := h.AbuseScore("a"); v != 0 {
+		t.Fatalf("%v != 0", v)
+	}
+	if n := len(h.abuseScores); n != 1 {
+		t.Fatalf("%d != 1", n)
+	}
+}
+
+func TestHeraldAbuseLoad(t *testing.T) {
+
+	// Create a policy that counts how often saved scores are loaded
+	var (
+		clock = newFakeClock()
+		loads = 0
+		h     = New()
+		r     = httptest.NewRequest(http.MethodGet, "/", nil)
+	)
+	h.Clock = clock
+	h.AbusePolicy = &AbusePolicy{
+		LoadScore: func(key string) *AbuseScore {
+			loads++
+			return nil
+		},
+	}
+
+	// Ensure that a key without a saved score is only looked up again once
+	// the miss has expired
+	for i, v := range []struct {
+		advance time.Duration
+		loads   int
+	}{
+		{0, 1},
+		{0, 1},
+		{abusePruneInterval, 2},
+	} {
+		clock.Advance(v.advance)
+		if err := h.checkBanned(httptest.NewRecorder(), r); err != nil {
+			t.Fatal(err)
+		}
+		if loads != v.loads {
+			t.Fatalf("%d: %d != %d", i, loads, v.loads)
+		}
+	}
+}
@@ -0,0 +1,53 @@
+package herald
+
+import (
+	"errors"
+	"math"
+	"net/http"
+	"strconv"
+)
+
+var (
+	// ErrAcceptLimited indicates that a connection was rejected because new
+	// connections are arriving faster than AcceptRate permits.
+	ErrAcceptLimited = errors.New("too many connections")
+)
+
+// waitAccept enforces AcceptRate. If the connection can be accepted within
+// AcceptMaxWait, waitAccept blocks until it can proceed; otherwise the
+// connection is rejected with 503 Service Unavailable and a Retry-After
+// header and ErrAcceptLimited is returned.
+func (h *Herald) waitAccept(w http.ResponseWriter, r *http.Request) error {
+	if h.AcceptRate <= 0 {
+		return nil
+	}
+	h.acceptMutex.Lock()
+	now := h.clock().Now()
+	if h.acceptBucket == nil {
+		h.acceptBucket = newTokenBucket(h.AcceptRate, h.AcceptBurst, now)
+	}
+	ok, d := h.acceptBucket.allow(1, now)
+	if !ok && d <= h.AcceptMaxWait {
+		d = h.acceptBucket.take(1, now)
+		ok = true
+	}
+	h.acceptMutex.Unlock()
+	if !ok {
+		h.reportRequestAbuse(r, AbuseRateLimited)
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(d.Seconds()))))
+		h.reject(w, r, http.StatusServiceUnavailable, &ConnectError{
+			StatusCode: http.StatusServiceUnavailable,
+			Code:       ErrorCodeRateLimited,
+			Message:    ErrAcceptLimited.Error(),
+		})
+		return ErrAcceptLimited
+	}
+	if d > 0 {
+		select {
+		case <-h.clock().After(d):
+		case <-r.Context().Done():
+			return r.Context().Err()
+		}
+	}
+	return nil
+}
@@ -0,0 +1,54 @@
+package herald
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestHeraldAcceptRate(t *testing.T) {
+
+	// Create the server with a limit of a single connection
+	s := newTestServer(func(h *Herald) {
+		h.AcceptRate = 0.001
+		h.AcceptBurst = 1
+		h.AbusePolicy = &AbusePolicy{
+			Weights: map[AbuseKind]float64{AbuseRateLimited: 10},
+		}
+	})
+	defer s.herald.Close()
+
+	// Make two requests; the first one fails to upgrade but counts toward
+	// the limit
+	var w *httptest.ResponseRecorder
+	for i := 0; i < 2; i++ {
+		w = httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		_, err := s.herald.AddClient(w, r, nil)
+		if i == 1 && !errors.Is(err, ErrAcceptLimited) {
+			t.Fatalf("%v != %v", err, ErrAcceptLimited)
+		}
+	}
+
+	// Ensure the second request was rejected
+	if w.Code != http.StatusServiceUnavailable {
+		t.Fatalf("%d != %d", w.Code, http.StatusServiceUnavailable)
+	}
+	if w.Header().Get("Retry-After") == "" {
+		t.Fatal("Retry-After header missing")
+	}
+	e := &ErrorMessage{}
+	if err := json.NewDecoder(w.Body).Decode(e); err != nil {
+		t.Fatal(err)
+	}
+	if e.Code != ErrorCodeRateLimited {
+		t.Fatalf("%s != %s", e.Code, ErrorCodeRateLimited)
+	}
+
+	// Ensure the rejection was added to the abuse score
+	if v := s.herald.AbuseScore("192.0.2.1"); v < 10 {
+		t.Fatalf("%v < 10", v)
+	}
+}
@@ -0,0 +1,44 @@
+package herald
+
+import (
+	"net/http"
+)
+
+const (
+
+	// instanceHeader is the response header containing the ID of the
+	// instance that accepted the connection when a backplane is in use.
+	instanceHeader = "X-Herald-Instance"
+
+	// instanceParam is the query parameter clients use to present the ID of
+	// the instance they were previously connected to when reconnecting.
+	instanceParam = "instance"
+)
+
+// affinityHeader adds the ID of this instance to the response headers if a
+// backplane is in use so that clients can present it when reconnecting. The
+// provided headers are not modified.
+func (h *Herald) affinityHeader(header http.Header) http.Header {
+	if h.backplane == nil {
+		return header
+	}
+	if header == nil {
+		header = http.Header{}
+	} else {
+		header = header.Clone()
+	}
+	header.Set(instanceHeader, h.id)
+	return header
+}
+
+// PreviousInstance returns the ID of the instance the client was connected to
+// before reconnecting or an empty string if the client did not provide one.
+// When a backplane is in use, the ID of the instance accepting a connection
+// is sent in the X-Herald-Instance response header and clients can present it
+// in the "instance" query parameter when they reconnect. If it matches ID(),
+// state for the client can be resumed locally without consulting the other
+// instances; load balancers can also use the header to route reconnects to
+// the same instance.
+func (c *Client) PreviousInstance() string {
+	return c.previousInstance
+}
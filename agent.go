@@ -0,0 +1,82 @@
+package herald
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"time"
+)
+
+// agentWriteTimeout is the maximum amount of time spent writing a response
+// to an agent-check connection.
+const agentWriteTimeout = 5 * time.Second
+
+// AgentCheck reports the load of the Herald in the format used by HAProxy
+// agent checks so that load balancers can steer new connections toward less
+// loaded instances. The response is one of:
+//
+//	up 75%     the instance is accepting connections with the given weight
+//	maint      maintenance mode is enabled
+//	drain      the instance is shutting down
+//
+// The weight is the percentage of Capacity that is unused.
+type AgentCheck struct {
+
+	// Capacity is the number of clients at which the weight reaches zero. If
+	// zero, the weight is always 100%.
+	Capacity int
+
+	herald *Herald
+}
+
+// AgentCheck creates a new agent check for the Herald with the specified
+// capacity.
+func (h *Herald) AgentCheck(capacity int) *AgentCheck {
+	return &AgentCheck{
+		Capacity: capacity,
+		herald:   h,
+	}
+}
+
+// Response returns the current response, including the trailing newline.
+func (a *AgentCheck) Response() string {
+	switch {
+	case a.herald.isClosing():
+		return "drain\n"
+	case a.herald.Maintenance() != nil:
+		return "maint\n"
+	}
+	weight := 100
+	if a.Capacity > 0 {
+		weight = 100 - a.herald.ClientCount()*100/a.Capacity
+		if weight < 0 {
+			weight = 0
+		}
+	}
+	return fmt.Sprintf("up %d%%\n", weight)
+}
+
+// ServeHTTP writes the response as plain text for agents that poll over HTTP.
+func (a *AgentCheck) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain")
+	w.Header().Set("Cache-Control", "no-store")
+	io.WriteString(w, a.Response())
+}
+
+// Serve accepts connections from the listener, writes the response to each,
+// and closes it, as expected by HAProxy's agent-check option. It returns when
+// the listener is closed.
+func (a *AgentCheck) Serve(l net.Listener) error {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return err
+		}
+		go func() {
+			defer conn.Close()
+			conn.SetWriteDeadline(time.Now().Add(agentWriteTimeout))
+			io.WriteString(conn, a.Response())
+		}()
+	}
+}
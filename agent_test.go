@@ -0,0 +1,46 @@
+package herald
+
+import (
+	"io"
+	"net"
+	"testing"
+)
+
+func TestAgentCheck(t *testing.T) {
+
+	// Create the server with a client and an agent check for four clients
+	s := newTestServer()
+	defer s.herald.Close()
+	c := newTestClient(t, s)
+	a := s.herald.AgentCheck(4)
+	if v := a.Response(); v != "up 75%\n" {
+		t.Fatalf("%q != \"up 75%%\\n\"", v)
+	}
+
+	// Ensure that the response is written to agent connections
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go a.Serve(l)
+	conn, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := io.ReadAll(conn)
+	conn.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != "up 75%\n" {
+		t.Fatalf("%q != \"up 75%%\\n\"", b)
+	}
+
+	// Enable maintenance mode
+	s.herald.SetMaintenance(newTestMessage(t, messageType1), false)
+	if v := a.Response(); v != "maint\n" {
+		t.Fatalf("%q != \"maint\\n\"", v)
+	}
+	c.close(s)
+}
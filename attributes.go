@@ -0,0 +1,43 @@
+package herald
+
+// SetAttribute attaches a value describing the client, such as a user ID or
+// tenant, under the specified key. Attributes are included in the errors
+// passed to ErrorHandler and in dead letters so that they can be forwarded to
+// logging, metrics, and tracing systems. When attributes are used as metric
+// labels, the set of keys and values should be kept small. Setting an empty
+// value removes the attribute.
+func (c *Client) SetAttribute(key, value string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if value == "" {
+		delete(c.attributes, key)
+		return
+	}
+	if c.attributes == nil {
+		c.attributes = make(map[string]string)
+	}
+	c.attributes[key] = value
+}
+
+// Attribute returns the value of the attribute with the specified key or an
+// empty string if it is not set.
+func (c *Client) Attribute(key string) string {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.attributes[key]
+}
+
+// Attributes returns a copy of all of the attributes attached to the client.
+// Nil is returned if there are none.
+func (c *Client) Attributes() map[string]string {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if len(c.attributes) == 0 {
+		return nil
+	}
+	attributes := make(map[string]string, len(c.attributes))
+	for k, v := range c.attributes {
+		attributes[k] = v
+	}
+	return attributes
+}
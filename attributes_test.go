@@ -0,0 +1,26 @@
+package herald
+
+import (
+	"testing"
+)
+
+func TestClientAttributes(t *testing.T) {
+	c := &Client{}
+	if c.Attributes() != nil {
+		t.Fatal("attributes are not nil")
+	}
+	c.SetAttribute("tenant", "a")
+	c.SetAttribute("region", "b")
+	c.SetAttribute("region", "")
+	if v := c.Attribute("tenant"); v != "a" {
+		t.Fatalf("%s != a", v)
+	}
+	a := c.Attributes()
+	if len(a) != 1 {
+		t.Fatalf("%d != 1", len(a))
+	}
+	a["tenant"] = "b"
+	if v := c.Attribute("tenant"); v != "a" {
+		t.Fatal("attributes were not copied")
+	}
+}
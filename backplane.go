@@ -0,0 +1,365 @@
+package herald
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"time"
+)
+
+const (
+
+	// broadcastChannel is the backplane channel used for broadcast messages.
+	broadcastChannel = "herald.broadcast"
+
+	// instanceChannelPrefix is combined with an instance ID to form the
+	// backplane channel for messages directed at that instance.
+	instanceChannelPrefix = "herald.instance."
+)
+
+// Discovery keeps track of the Herald instances that make up a cluster.
+// Implementations must be safe for concurrent use.
+type Discovery interface {
+
+	// Join announces that the instance with the specified ID is available.
+	Join(ctx context.Context, id string) error
+
+	// Leave announces that the instance with the specified ID is no longer
+	// available.
+	Leave(ctx context.Context, id string) error
+
+	// Members returns the IDs of all available instances.
+	Members(ctx context.Context) ([]string, error)
+}
+
+// Backplane connects multiple Herald instances together so that messages
+// broadcast on one instance are delivered to the clients of every instance.
+// Implementations must be safe for concurrent use.
+type Backplane interface {
+	Discovery
+
+	// Publish sends the payload to all subscribers of the channel on every
+	// instance, including the one publishing it.
+	Publish(ctx context.Context, channel string, payload []byte) error
+
+	// Subscribe registers a function that is invoked for each payload
+	// published to the channel. The returned function cancels the
+	// subscription.
+	Subscribe(channel string, fn func(payload []byte)) (func(), error)
+}
+
+// backplaneEnvelope wraps messages published to the backplane. Origin and Seq
+// identify the instance that published the envelope and its position in the
+// sequence of envelopes published by that instance. Index and Key are set for
+// messages directed at the clients with a specific index key on the instance
+// that owns the key. Group is set
+// for messages broadcast to the clients in a group and Room for messages
+// broadcast to the clients in a room. Ephemeral is set for messages broadcast
+// with SendEphemeral. Meta carries the fields of each message that are not
+// sent to clients and is omitted if none of them are set.
+type backplaneEnvelope struct {
+	Origin    string         `json:"origin"`
+	Seq       uint64         `json:"seq,omitempty"`
+	Messages  []*Message     `json:"messages"`
+	Meta      []*messageMeta `json:"meta,omitempty"`
+	Index     string         `json:"index,omitempty"`
+	Key       string         `json:"key,omitempty"`
+	Group     string         `json:"group,omitempty"`
+	Room      string         `json:"room,omitempty"`
+	Ephemeral bool           `json:"ephemeral,omitempty"`
+}
+
+// messageMeta stores the fields of a message that are not sent to clients.
+type messageMeta struct {
+	Key      string     `json:"key,omitempty"`
+	Retain   bool       `json:"retain,omitempty"`
+	Deadline *time.Time `json:"deadline,omitempty"`
+}
+
+// encodeMeta sets Meta from the messages if any of them have fields that are
+// not sent to clients.
+func (e *backplaneEnvelope) encodeMeta() {
+	var (
+		meta = make([]*messageMeta, len(e.Messages))
+		set  bool
+	)
+	for i, m := range e.Messages {
+		v := &messageMeta{
+			Key:    m.Key,
+			Retain: m.Retain,
+		}
+		if !m.Deadline.IsZero() {
+			d := m.Deadline
+			v.Deadline = &d
+		}
+		if v.Key != "" || v.Retain || v.Deadline != nil {
+			set = true
+		}
+		meta[i] = v
+	}
+	if set {
+		e.Meta = meta
+	}
+}
+
+// decodeMeta restores the fields of the messages that are not sent to
+// clients from Meta.
+func (e *backplaneEnvelope) decodeMeta() {
+	for i, v := range e.Meta {
+		if i == len(e.Messages) || v == nil {
+			break
+		}
+		m := e.Messages[i]
+		m.Key = v.Key
+		m.Retain = v.Retain
+		if v.Deadline != nil {
+			m.Deadline = *v.Deadline
+		}
+	}
+}
+
+type backplanePayload struct {
+	channel string
+	payload []byte
+}
+
+type backplaneState struct {
+	backplane    Backplane
+	discovery    Discovery
+	unsubscribes []func()
+	mutex        sync.Mutex
+	queue        []*backplanePayload
+	signalChan   chan struct{}
+	ring         *hashRing
+	seq          uint64
+	windows      map[string]*seqWindow
+}
+
+// SetBackplane connects the Herald to other instances using the provided
+// backplane. Messages broadcast to all clients are published to the backplane
+// and messages published by other instances are broadcast to local clients.
+// This method must be called before Start().
+func (h *Herald) SetBackplane(b Backplane) error {
+	d := h.Discovery
+	if d == nil {
+		d = b
+	}
+	if err := d.Join(context.Background(), h.id); err != nil {
+		return err
+	}
+	s := &backplaneState{
+		backplane:  b,
+		discovery:  d,
+		signalChan: make(chan struct{}, 1),
+	}
+	for channel, fn := range map[string]func([]byte){
+		broadcastChannel:             h.receiveBroadcast,
+		instanceChannelPrefix + h.id: h.receiveDirected,
+	} {
+		unsubscribe, err := b.Subscribe(channel, fn)
+		if err != nil {
+			for _, fn := range s.unsubscribes {
+				fn()
+			}
+			d.Leave(context.Background(), h.id)
+			return err
+		}
+		s.unsubscribes = append(s.unsubscribes, unsubscribe)
+	}
+	h.backplane = s
+	h.refreshMembers()
+	go h.publishLoop()
+	if h.MembershipInterval > 0 {
+		go h.membershipLoop()
+	}
+	return nil
+}
+
+// ID returns the unique ID of this instance used to identify it on the
+// backplane.
+func (h *Herald) ID() string {
+	return h.id
+}
+
+// decodeEnvelope decodes a payload received from the backplane, returning nil
+// if it is invalid, originated from this instance, or was already received.
+func (h *Herald) decodeEnvelope(payload []byte) *backplaneEnvelope {
+	e := &backplaneEnvelope{}
+	if err := json.Unmarshal(payload, e); err != nil {
+		h.reportError(&ClientError{
+			Kind: ErrorBackplane,
+			Err:  err,
+		})
+		return nil
+	}
+	if e.Origin == h.id || !h.backplane.acceptEnvelope(e) {
+		return nil
+	}
+	e.decodeMeta()
+	return e
+}
+
+// receiveBroadcast is invoked when a payload is received on the broadcast
+// channel.
+func (h *Herald) receiveBroadcast(payload []byte) {
+	h.waitForMemory()
+	if e := h.decodeEnvelope(payload); e != nil {
+		h.queueSend(&sendParams{
+			messages:  e.Messages,
+			group:     e.Group,
+			room:      e.Room,
+			remote:    true,
+			ephemeral: e.Ephemeral,
+		})
+	}
+}
+
+// receiveDirected is invoked when a payload is received on the channel for
+// this instance.
+func (h *Herald) receiveDirected(payload []byte) {
+	h.waitForMemory()
+	if e := h.decodeEnvelope(payload); e != nil {
+		if clients := h.Lookup(e.Index, e.Key); len(clients) != 0 {
+			h.SendAll(e.Messages, clients)
+		}
+	}
+}
+
+// publish queues the envelope for publishing to the backplane on the
+// specified channel.
+func (h *Herald) publish(channel string, e *backplaneEnvelope) {
+	e.Origin = h.id
+	e.Seq = h.backplane.nextSeq()
+	e.encodeMeta()
+	b, err := json.Marshal(e)
+	if err != nil {
+		h.reportError(&ClientError{
+			Kind: ErrorBackplane,
+			Err:  err,
+		})
+		return
+	}
+	s := h.backplane
+	s.mutex.Lock()
+	s.queue = append(s.queue, &backplanePayload{
+		channel: channel,
+		payload: b,
+	})
+	s.mutex.Unlock()
+	select {
+	case s.signalChan <- struct{}{}:
+	default:
+	}
+}
+
+// publishLoop publishes queued payloads to the backplane in order until the
+// Herald is closed.
+func (h *Herald) publishLoop() {
+	s := h.backplane
+	for {
+		s.mutex.Lock()
+		q := s.queue
+		s.queue = nil
+		s.mutex.Unlock()
+		for _, p := range q {
+			if err := s.backplane.Publish(context.Background(), p.channel, p.payload); err != nil {
+				h.reportError(&ClientError{
+					Kind: ErrorBackplane,
+					Err:  err,
+				})
+			}
+		}
+		select {
+		case <-s.signalChan:
+		case <-h.closedChan:
+			return
+		}
+	}
+}
+
+// refreshMembers retrieves the list of members and rebuilds the hash ring.
+func (h *Herald) refreshMembers() {
+	s := h.backplane
+	members, err := s.discovery.Members(context.Background())
+	if err != nil {
+		h.reportError(&ClientError{
+			Kind: ErrorBackplane,
+			Err:  err,
+		})
+		return
+	}
+	r := newHashRing(members)
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.ring = r
+	s.pruneWindows(members)
+}
+
+// membershipLoop periodically refreshes the list of members until the Herald
+// is closed.
+func (h *Herald) membershipLoop() {
+	tickChan, stop := h.clock().NewTicker(h.MembershipInterval)
+	defer stop()
+	for {
+		select {
+		case <-tickChan:
+			h.refreshMembers()
+		case <-h.closedChan:
+			return
+		}
+	}
+}
+
+// Owner returns the ID of the instance that owns the specified key. Keys are
+// assigned to instances using consistent hashing, so each key is owned by
+// exactly one instance as long as the instances agree on the list of members.
+// If no backplane is in use, the ID of this instance is returned.
+func (h *Herald) Owner(key string) string {
+	if h.backplane == nil {
+		return h.id
+	}
+	s := h.backplane
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if o := s.ring.owner(key); o != "" {
+		return o
+	}
+	return h.id
+}
+
+// SendToIndex sends the message to the clients indexed under the key in the
+// named index (see AddIndex). If a backplane is in use and the key is owned
+// by another instance, the message is forwarded only to that instance, which
+// then sends it to its own matching clients. Clients must therefore connect
+// to the instance that owns their key, for example by having the load
+// balancer route them using Owner(). ErrClosed is returned if the Herald is
+// shutting down.
+func (h *Herald) SendToIndex(name, key string, message *Message) error {
+	if h.isClosing() {
+		return ErrClosed
+	}
+	if o := h.Owner(key); o != h.id {
+		h.publish(instanceChannelPrefix+o, &backplaneEnvelope{
+			Messages: []*Message{message},
+			Index:    name,
+			Key:      key,
+		})
+		return nil
+	}
+	if clients := h.Lookup(name, key); len(clients) != 0 {
+		return h.Send(message, clients)
+	}
+	return nil
+}
+
+// closeBackplane cancels the subscriptions and removes the instance from the
+// list of members.
+func (h *Herald) closeBackplane() {
+	if h.backplane == nil {
+		return
+	}
+	for _, fn := range h.backplane.unsubscribes {
+		fn()
+	}
+	h.backplane.discovery.Leave(context.Background(), h.id)
+}
@@ -0,0 +1,89 @@
+// Package memory provides an in-process backplane for connecting multiple
+// Herald instances within the same process. It is primarily useful for tests.
+package memory
+
+import (
+	"context"
+	"sort"
+	"sync"
+)
+
+type subscription struct {
+	channel string
+	fn      func(payload []byte)
+}
+
+// Backplane implements herald.Backplane using in-memory data structures.
+type Backplane struct {
+	mutex         sync.Mutex
+	subscriptions map[*subscription]struct{}
+	members       map[string]struct{}
+}
+
+// New creates a new in-memory backplane.
+func New() *Backplane {
+	return &Backplane{
+		subscriptions: make(map[*subscription]struct{}),
+		members:       make(map[string]struct{}),
+	}
+}
+
+// Publish invokes the functions subscribed to the channel.
+func (b *Backplane) Publish(ctx context.Context, channel string, payload []byte) error {
+	b.mutex.Lock()
+	var fns []func([]byte)
+	for s := range b.subscriptions {
+		if s.channel == channel {
+			fns = append(fns, s.fn)
+		}
+	}
+	b.mutex.Unlock()
+	for _, fn := range fns {
+		fn(payload)
+	}
+	return nil
+}
+
+// Subscribe registers the function for payloads published to the channel.
+func (b *Backplane) Subscribe(channel string, fn func(payload []byte)) (func(), error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	s := &subscription{
+		channel: channel,
+		fn:      fn,
+	}
+	b.subscriptions[s] = struct{}{}
+	return func() {
+		b.mutex.Lock()
+		defer b.mutex.Unlock()
+		delete(b.subscriptions, s)
+	}, nil
+}
+
+// Join adds the instance to the list of members.
+func (b *Backplane) Join(ctx context.Context, id string) error {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.members[id] = struct{}{}
+	return nil
+}
+
+// Leave removes the instance from the list of members.
+func (b *Backplane) Leave(ctx context.Context, id string) error {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	delete(b.members, id)
+	return nil
+}
+
+// Members returns the sorted IDs of all members.
+func (b *Backplane) Members(ctx context.Context) ([]string, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	ids := make([]string, 0, len(b.members))
+	for id := range b.members {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids, nil
+}
@@ -0,0 +1,227 @@
+package herald
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/websocket"
+	"github.com/nathan-osman/go-herald/backplane/memory"
+)
+
+func TestBackplane(t *testing.T) {
+
+	// Create two servers connected by a backplane with a client each
+	var (
+		b     = memory.New()
+		setup = func(h *Herald) {
+			if err := h.SetBackplane(b); err != nil {
+				t.Fatal(err)
+			}
+		}
+		s1 = newTestServer(setup)
+		s2 = newTestServer(setup)
+		c1 = newTestClient(t, s1)
+		c2 = newTestClient(t, s2)
+		m  = newTestMessage(t, messageType1)
+	)
+	defer s1.herald.Close()
+	defer s2.herald.Close()
+
+	// Ensure both instances are members
+	members, err := b.Members(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	ids := []string{s1.herald.ID(), s2.herald.ID()}
+	sort.Strings(ids)
+	if !reflect.DeepEqual(members, ids) {
+		t.Fatal("member list does not match")
+	}
+
+	// Broadcast a message from the first server and ensure that both clients
+	// receive it exactly once
+	s1.herald.Send(m, nil)
+	c1.receive(t, s1, m)
+	c2.receive(t, s2, m)
+	m2 := newTestMessage(t, messageType2)
+	s2.herald.Send(m2, nil)
+	c1.receive(t, s1, m2)
+	c2.receive(t, s2, m2)
+
+	// Ensure that a retained message is retained by both instances
+	m3 := newTestMessage(t, messageType1)
+	m3.Retain = true
+	s1.herald.Send(m3, nil)
+	c1.receive(t, s1, m3)
+	c2.receive(t, s2, m3)
+	if s2.herald.Retained(messageType1) == nil {
+		t.Fatal("message not retained")
+	}
+
+	c1.close(s1)
+	c2.close(s2)
+}
+
+func TestBackplaneSendToIndex(t *testing.T) {
+
+	// Create two servers connected by a backplane, indexing clients by data
+	var (
+		b     = memory.New()
+		setup = func(h *Herald) {
+			if err := h.SetBackplane(b); err != nil {
+				t.Fatal(err)
+			}
+			h.AddIndex("data", func(c *Client) []string {
+				return []string{c.Data.(string)}
+			})
+		}
+		s1 = newTestServer(setup)
+		s2 = newTestServer(setup)
+		m  = newTestMessage(t, messageType1)
+	)
+	defer s1.herald.Close()
+	defer s2.herald.Close()
+
+	// Refresh the membership on the first server since it joined before the
+	// second one did
+	s1.herald.refreshMembers()
+	if s1.herald.Owner(clientData) != s2.herald.Owner(clientData) {
+		t.Fatal("servers disagree on owner")
+	}
+
+	// Connect a client to the owning server and send from the other one
+	owner, other := s1, s2
+	if s2.herald.Owner(clientData) == s2.herald.ID() {
+		owner, other = s2, s1
+	}
+	c := newTestClient(t, owner)
+	other.herald.SendToIndex("data", clientData, m)
+	c.receive(t, owner, m)
+	c.close(owner)
+
+	// Ensure that sending from the owning server delivers the message to
+	// its own clients
+	c = newTestClient(t, owner)
+	owner.herald.SendToIndex("data", clientData, m)
+	c.receive(t, owner, m)
+	c.close(owner)
+}
+
+func TestBackplaneDiscovery(t *testing.T) {
+
+	// Create a server that uses a separate discovery mechanism
+	var (
+		b = memory.New()
+		d = memory.New()
+		s = newTestServer(func(h *Herald) {
+			h.Discovery = d
+			if err := h.SetBackplane(b); err != nil {
+				t.Fatal(err)
+			}
+		})
+	)
+	defer s.herald.Close()
+
+	// Ensure the instance joined the discovery mechanism only
+	for _, v := range []struct {
+		discovery Discovery
+		members   []string
+	}{
+		{discovery: b, members: []string{}},
+		{discovery: d, members: []string{s.herald.ID()}},
+	} {
+		members, err := v.discovery.Members(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(members, v.members) {
+			t.Fatal("member list does not match")
+		}
+	}
+}
+
+func TestBackplaneAffinity(t *testing.T) {
+
+	// Create a server connected to a backplane
+	s := newTestServer(func(h *Herald) {
+		if err := h.SetBackplane(memory.New()); err != nil {
+			t.Fatal(err)
+		}
+	})
+	defer s.herald.Close()
+
+	// Connect a client that presents a previous instance
+	s.clientAddedWG.Add(1)
+	var (
+		client *Client
+		server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			c, err := s.herald.AddClient(w, r, clientData)
+			if err != nil {
+				t.Log(err)
+				t.Fail()
+				return
+			}
+			client = c
+		}))
+		addr            = strings.Replace(server.URL, "http", "ws", 1) + "?instance=previous"
+		conn, resp, err = websocket.DefaultDialer.Dial(addr, nil)
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server.Close()
+	s.clientAddedWG.Wait()
+
+	// Ensure the instance was sent and the previous instance recorded
+	if v := resp.Header.Get(instanceHeader); v != s.herald.ID() {
+		t.Fatalf("%s != %s", v, s.herald.ID())
+	}
+	if v := client.PreviousInstance(); v != "previous" {
+		t.Fatalf("%s != previous", v)
+	}
+	(&testClient{conn: conn}).close(s)
+}
+
+func TestBackplaneDeduplication(t *testing.T) {
+
+	// Create two servers connected by a backplane and record the payloads
+	// published to it
+	var (
+		b     = memory.New()
+		setup = func(h *Herald) {
+			if err := h.SetBackplane(b); err != nil {
+				t.Fatal(err)
+			}
+		}
+		s1          = newTestServer(setup)
+		s2          = newTestServer(setup)
+		c2          = newTestClient(t, s2)
+		m1          = newTestMessage(t, messageType1)
+		m2          = newTestMessage(t, messageType2)
+		payloadChan = make(chan []byte, 2)
+	)
+	defer s1.herald.Close()
+	defer s2.herald.Close()
+	unsubscribe, err := b.Subscribe(broadcastChannel, func(payload []byte) {
+		payloadChan <- payload
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer unsubscribe()
+
+	// Broadcast a message and publish it again, as a bridge between
+	// backplanes might, and ensure it is only received once
+	s1.herald.Send(m1, nil)
+	c2.receive(t, s2, m1)
+	b.Publish(context.Background(), broadcastChannel, <-payloadChan)
+	s1.herald.Send(m2, nil)
+	c2.receive(t, s2, m2)
+
+	c2.close(s2)
+}
@@ -0,0 +1,73 @@
+package herald
+
+import (
+	"time"
+)
+
+// defaultBatchInterval is used when ClientBatchInterval is zero.
+const defaultBatchInterval = time.Second
+
+// clientBatch accumulates membership changes for ClientBatchHandler. Pending
+// contains the clients in added that are still connected, so that clients
+// which disconnect before the batch is delivered can be omitted without
+// searching added. It is only accessed by the run loop.
+type clientBatch struct {
+	added   []*Client
+	pending map[*Client]struct{}
+	removed []*Client
+}
+
+// batchTicker returns a channel for delivering batches and a function that
+// stops it. The channel is nil if ClientBatchHandler was not provided.
+func (h *Herald) batchTicker() (<-chan time.Time, func()) {
+	if h.ClientBatchHandler == nil {
+		return nil, func() {}
+	}
+	d := h.ClientBatchInterval
+	if d == 0 {
+		d = defaultBatchInterval
+	}
+	return h.clock().NewTicker(d)
+}
+
+// batchAdded records that a client connected.
+func (h *Herald) batchAdded(c *Client) {
+	if h.ClientBatchHandler != nil {
+		if h.batch.pending == nil {
+			h.batch.pending = make(map[*Client]struct{})
+		}
+		h.batch.added = append(h.batch.added, c)
+		h.batch.pending[c] = struct{}{}
+	}
+}
+
+// batchRemoved records that a client disconnected. If the client connected
+// since the last batch was delivered, it is omitted from both lists.
+func (h *Herald) batchRemoved(c *Client) {
+	if h.ClientBatchHandler == nil {
+		return
+	}
+	if _, ok := h.batch.pending[c]; ok {
+		delete(h.batch.pending, c)
+		return
+	}
+	h.batch.removed = append(h.batch.removed, c)
+}
+
+// flushBatch passes the accumulated changes to ClientBatchHandler if there
+// are any.
+func (h *Herald) flushBatch() {
+	if len(h.batch.pending) == 0 && len(h.batch.removed) == 0 {
+		h.batch = clientBatch{}
+		return
+	}
+	b := h.batch
+	h.batch = clientBatch{}
+	var added []*Client
+	for _, c := range b.added {
+		if _, ok := b.pending[c]; ok {
+			added = append(added, c)
+		}
+	}
+	h.ClientBatchHandler(added, b.removed)
+}
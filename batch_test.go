@@ -0,0 +1,80 @@
+package herald
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestHeraldClientBatch(t *testing.T) {
+
+	// Create the server with a batch handler
+	type batch struct {
+		added   []*Client
+		removed []*Client
+	}
+	batchChan := make(chan *batch, 2)
+	s := newTestServer(func(h *Herald) {
+		h.ClientBatchInterval = 10 * time.Millisecond
+		h.ClientBatchHandler = func(added, removed []*Client) {
+			batchChan <- &batch{added: added, removed: removed}
+		}
+	})
+	defer s.herald.Close()
+
+	// Wait for batches until the expected number of changes are delivered,
+	// since they may span more than one interval
+	expect := func(added, removed int) {
+		a, r := 0, 0
+		for a < added || r < removed {
+			select {
+			case b := <-batchChan:
+				a += len(b.added)
+				r += len(b.removed)
+			case <-time.After(receiveTimeout):
+				t.Fatal("timeout reached")
+			}
+		}
+		if a != added || r != removed {
+			t.Fatalf("%d/%d != %d/%d", a, r, added, removed)
+		}
+	}
+
+	// Connect two clients and ensure they are delivered
+	c1 := newTestClient(t, s)
+	c2 := newTestClient(t, s)
+	expect(2, 0)
+
+	// Disconnect both clients and ensure they are delivered
+	c1.close(s)
+	c2.close(s)
+	expect(0, 2)
+}
+
+func TestFlushBatch(t *testing.T) {
+
+	// Record the changes passed to the handler
+	var added, removed []*Client
+	h := New()
+	h.ClientBatchHandler = func(a, r []*Client) {
+		added, removed = a, r
+	}
+
+	// Ensure that a client which connects and disconnects within the same
+	// batch is omitted while the others keep their order
+	var (
+		c1 = &Client{group: "1"}
+		c2 = &Client{group: "2"}
+		c3 = &Client{group: "3"}
+		c4 = &Client{group: "4"}
+	)
+	h.batchAdded(c1)
+	h.batchAdded(c2)
+	h.batchAdded(c3)
+	h.batchRemoved(c2)
+	h.batchRemoved(c4)
+	h.flushBatch()
+	if !reflect.DeepEqual(added, []*Client{c1, c3}) || !reflect.DeepEqual(removed, []*Client{c4}) {
+		t.Fatalf("unexpected batch: %v, %v", added, removed)
+	}
+}
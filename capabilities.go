@@ -0,0 +1,91 @@
+package herald
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// capabilitiesParam is the name of the query parameter clients use to
+// advertise their capabilities when connecting.
+const capabilitiesParam = "capabilities"
+
+// Capabilities describes the features supported by a client. Clients
+// advertise them with a comma-separated list in the "capabilities" query
+// parameter of the WebSocket URL, for example:
+//
+//	wss://example.com/ws?capabilities=binary,ack,max-message-size=65536,version=2
+type Capabilities struct {
+
+	// Version is the protocol version spoken by the client, which
+	// determines the version of each type registered with RegisterType()
+	// that it sends and receives. Zero indicates that the client did not
+	// specify a version.
+	Version int
+
+	// Keepalive is the interval at which the client asked to be pinged or
+	// zero if it did not specify one. It is given in milliseconds, for
+	// example "keepalive=60000".
+	Keepalive time.Duration
+
+	// Binary indicates that the client can receive binary messages.
+	Binary bool
+
+	// Ack indicates that the client acknowledges the messages it receives.
+	Ack bool
+
+	// MaxMessageSize is the size of the largest message the client can
+	// receive in bytes or zero if there is no limit.
+	MaxMessageSize int
+
+	// Flags contains the other capabilities advertised by the client.
+	Flags []string
+}
+
+// Has determines whether the client advertised the specified flag.
+func (c *Capabilities) Has(flag string) bool {
+	for _, f := range c.Flags {
+		if f == flag {
+			return true
+		}
+	}
+	return false
+}
+
+// parseCapabilities reads the capabilities from the request's query string.
+// Values that cannot be parsed are ignored.
+func parseCapabilities(r *http.Request) *Capabilities {
+	c := &Capabilities{}
+	for _, v := range strings.Split(r.URL.Query().Get(capabilitiesParam), ",") {
+		v = strings.TrimSpace(v)
+		switch {
+		case v == "":
+		case v == "binary":
+			c.Binary = true
+		case v == "ack":
+			c.Ack = true
+		case strings.HasPrefix(v, "version="):
+			if n, err := strconv.Atoi(strings.TrimPrefix(v, "version=")); err == nil && n > 0 {
+				c.Version = n
+			}
+		case strings.HasPrefix(v, "keepalive="):
+			if n, err := strconv.Atoi(strings.TrimPrefix(v, "keepalive=")); err == nil && n > 0 {
+				c.Keepalive = time.Duration(n) * time.Millisecond
+			}
+		case strings.HasPrefix(v, "max-message-size="):
+			if n, err := strconv.Atoi(strings.TrimPrefix(v, "max-message-size=")); err == nil && n > 0 {
+				c.MaxMessageSize = n
+			}
+		default:
+			c.Flags = append(c.Flags, v)
+		}
+	}
+	return c
+}
+
+// Capabilities returns the capabilities the client advertised when it
+// connected.
+func (c *Client) Capabilities() *Capabilities {
+	return c.capabilities
+}
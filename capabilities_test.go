@@ -0,0 +1,37 @@
+package herald
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestParseCapabilities(t *testing.T) {
+	var (
+		r = httptest.NewRequest(
+			http.MethodGet,
+			"/?capabilities=binary,max-message-size=1024,resume,max-message-size=x,version=2,keepalive=30000",
+			nil,
+		)
+		c = parseCapabilities(r)
+	)
+	if !c.Binary {
+		t.Fatal("binary not set")
+	}
+	if c.Ack {
+		t.Fatal("ack set")
+	}
+	if c.Keepalive != 30*time.Second {
+		t.Fatalf("%s != 30s", c.Keepalive)
+	}
+	if c.Version != 2 {
+		t.Fatalf("%d != 2", c.Version)
+	}
+	if c.MaxMessageSize != 1024 {
+		t.Fatalf("%d != 1024", c.MaxMessageSize)
+	}
+	if !c.Has("resume") || c.Has("max-message-size=x") {
+		t.Fatalf("unexpected flags: %v", c.Flags)
+	}
+}
@@ -1,58 +1,306 @@
 package herald
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"sync"
+	"time"
 
 	"github.com/gorilla/websocket"
 )
 
+var (
+	// ErrClientClosed indicates that the client has disconnected.
+	ErrClientClosed = errors.New("client is closed")
+
+	// ErrExpired indicates that a message was discarded because its deadline
+	// passed before it could be written to the client.
+	ErrExpired = errors.New("message deadline exceeded")
+)
+
+// outgoing is a message queued for writing to a client.
+type outgoing struct {
+	message   *Message
+	receipt   *Receipt
+	key       string
+	grouped   bool
+	client    *Client
+	flushChan chan struct{}
+}
+
+// complete records the outcome of writing the message.
+func (o *outgoing) complete(err error) {
+	if o.receipt != nil {
+		o.receipt.complete(o.client, err)
+	}
+}
+
 // Client maintains information about an active client.
 type Client struct {
-	Data            interface{}
-	conn            *websocket.Conn
-	readChan        chan *Message
-	writeChan       chan *Message
-	writeClosedChan chan struct{}
-	closedChan      chan struct{}
+	Data               interface{}
+	herald             *Herald
+	group              string
+	capabilities       *Capabilities
+	previousInstance   string
+	session            *session
+	request            *http.Request
+	attributes         map[string]string
+	ctx                context.Context
+	cancel             context.CancelFunc
+	conn               *websocket.Conn
+	readChan           chan *Message
+	queue              *queue
+	writeClosedChan    chan struct{}
+	closedChan         chan struct{}
+	retry              *pendingRetry
+	mutex              sync.Mutex
+	throttle           *Throttle
+	foregroundThrottle *Throttle
+	background         bool
+	priority           int
+	lastActive         time.Time
+	linger             time.Duration
+	keepalive          time.Duration
+	lastTimeSync       int64
+	key                []byte
+	abuseKey           string
+	abuseThrottled     bool
+	quarantined        bool
+	closeCode          int
+	closeText          string
+	faults             *faultState
+	messageBucket      *tokenBucket
+	byteBucket         *tokenBucket
 }
 
 func (c *Client) readLoop() {
 	defer close(c.closedChan)
+	defer c.cancel()
 	defer func() {
 		<-c.writeClosedChan
 	}()
 	defer close(c.readChan)
 	defer c.conn.Close()
+	handshake := true
+	if d := c.herald.HandshakeTimeout; d > 0 {
+		c.conn.SetReadDeadline(time.Now().Add(d))
+	} else {
+		c.extendDeadline()
+	}
+	c.conn.SetPongHandler(func(string) error {
+		if !handshake {
+			c.extendDeadline()
+		}
+		return nil
+	})
 	for {
 		messageType, p, err := c.conn.ReadMessage()
 		if err != nil {
+			if handshake && c.herald.HandshakeTimeout > 0 && isTimeout(err) {
+				c.herald.upgradeFailed(c.request, UpgradeHandshakeTimeout, err)
+			}
 			return
 		}
-		if messageType != websocket.TextMessage {
+		m, err := decodeMessage(messageType, p)
+		if err != nil {
+			c.herald.reportError(&ClientError{
+				Kind:   ErrorProtocol,
+				Client: c,
+				Err:    err,
+			})
+			c.herald.ReportAbuse(c, AbuseInvalidMessage)
+			c.herald.SendError(c, ErrorCodeInvalid, err.Error(), "")
 			continue
 		}
-		m := &Message{}
-		if err := json.Unmarshal(p, m); err != nil {
+		if err := c.upgradeMessage(m); err != nil {
+			c.herald.reportError(&ClientError{
+				Kind:    ErrorProtocol,
+				Client:  c,
+				Message: m,
+				Err:     err,
+			})
+			c.herald.ReportAbuse(c, AbuseInvalidMessage)
+			c.herald.SendError(c, ErrorCodeInvalid, err.Error(), "")
+			c.herald.deadLetter(m, c, 0, err)
 			continue
 		}
+		if handshake {
+			if err := c.handshake(m); err != nil {
+				c.herald.upgradeFailed(c.request, UpgradeHandshakeInvalid, err)
+				c.herald.ReportAbuse(c, AbuseInvalidMessage)
+				c.herald.reportError(&ClientError{
+					Kind:    ErrorProtocol,
+					Client:  c,
+					Message: m,
+					Err:     err,
+				})
+				return
+			}
+			handshake = false
+		} else {
+			c.extendDeadline()
+		}
+		c.touch()
 		c.readChan <- m
 	}
 }
 
+// handshake verifies the first message received from the client, performs
+// the key exchange, and replaces the handshake deadline with the keepalive
+// deadline.
+func (c *Client) handshake(m *Message) error {
+	if t := c.herald.HandshakeType; t != "" && m.Type != t {
+		return ErrHandshakeExpected
+	}
+	if err := c.exchangeKey(m); err != nil {
+		return err
+	}
+	c.extendDeadline()
+	return nil
+}
+
+// reportWriteError reports a failure to write a message unless it was caused
+// by the connection being closed.
+func (c *Client) reportWriteError(m *Message, err error) {
+	if errors.Is(err, net.ErrClosed) || errors.Is(err, websocket.ErrCloseSent) {
+		return
+	}
+	c.herald.reportError(&ClientError{
+		Kind:    ErrorWrite,
+		Client:  c,
+		Message: m,
+		Err:     err,
+	})
+}
+
 func (c *Client) writeLoop() {
 	defer close(c.writeClosedChan)
-	for m := range c.writeChan {
-		b, err := json.Marshal(m)
-		if err != nil {
-			break
+	for {
+		o := c.queue.pop()
+		if o == nil {
+
+			// The queue was closed; if the connection is still open, let the
+			// client know it is being disconnected
+			c.writeCloseFrame()
+			c.conn.Close()
+			return
+		}
+		if o.flushChan != nil {
+			close(o.flushChan)
+			continue
+		}
+		if d := o.message.Deadline; !d.IsZero() && c.herald.clock().Now().After(d) {
+			c.herald.countExpired()
+			c.herald.recordDrop(c, o.message, "expired")
+			o.complete(ErrExpired)
+			continue
+		}
+		drop, disconnect := c.injectFaults()
+		if drop {
+			o.complete(nil)
+		} else {
+			var err error
+			c.herald.withLabels(c, o.message, func() {
+				err = c.write(o.message)
+			})
+			o.complete(err)
+		}
+		if disconnect {
+			c.conn.Close()
 		}
-		c.conn.WriteMessage(websocket.TextMessage, b)
 	}
 }
 
-// Close disconnects the client. To ensure the client has completely shut down,
-// use the Wait() method.
+// write converts the message to the version the client understands, encodes
+// it, and writes it to the socket. If the message cannot be written, the
+// failure is reported and the client is disconnected. Write errors are not
+// retried since the connection cannot be used after one occurs.
+func (c *Client) write(m *Message) error {
+	v, err := c.downgradeMessage(m)
+	if err != nil {
+		c.reportWriteError(m, err)
+		return err
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		c.reportWriteError(m, err)
+		return err
+	}
+	if d := c.throttleDelay(len(b)); d > 0 {
+		select {
+		case <-c.herald.clock().After(d):
+		case <-c.ctx.Done():
+		}
+	}
+	if err := c.conn.WriteMessage(websocket.TextMessage, b); err != nil {
+		c.reportWriteError(m, err)
+		c.conn.Close()
+		return err
+	}
+	return nil
+}
+
+// Context returns a context that is cancelled when the client disconnects or
+// when a message handler for the client exceeds the configured timeout.
+func (c *Client) Context() context.Context {
+	return c.ctx
+}
+
+// Flush waits until the messages currently in the client's queue have been
+// written to the socket or the context is done. Messages that have been sent
+// but not yet queued for the client are not waited for. ErrClientClosed is
+// returned if the client has already disconnected.
+func (c *Client) Flush(ctx context.Context) error {
+	flushChan := make(chan struct{})
+	if !c.queue.mark(&outgoing{flushChan: flushChan}) {
+		return ErrClientClosed
+	}
+	select {
+	case <-flushChan:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// SetLinger specifies how long Close() waits for the messages in the client's
+// queue to be written before disconnecting it. A value of zero causes Close()
+// to disconnect the client immediately.
+func (c *Client) SetLinger(d time.Duration) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.linger = d
+}
+
+// Close disconnects the client. If a linger duration was set, no further
+// messages are queued and the client is disconnected once its queued messages
+// have been written and a close frame sent or the linger duration elapses,
+// whichever happens first. To ensure the client has completely shut down, use
+// the Wait() method.
 func (c *Client) Close() {
+	c.mutex.Lock()
+	linger := c.linger
+	c.mutex.Unlock()
+	if linger == 0 {
+		c.CloseNow()
+		return
+	}
+	c.queue.close()
+	go func() {
+		select {
+		case <-c.herald.clock().After(linger):
+			c.CloseNow()
+		case <-c.closedChan:
+		}
+	}()
+}
+
+// CloseNow disconnects the client immediately, discarding any queued
+// messages, regardless of the linger duration.
+func (c *Client) CloseNow() {
 	c.conn.Close()
 }
 
@@ -60,3 +308,9 @@ func (c *Client) Close() {
 func (c *Client) Wait() {
 	<-c.closedChan
 }
+
+// Done returns a channel that is closed once the client goroutines have shut
+// down.
+func (c *Client) Done() <-chan struct{} {
+	return c.closedChan
+}
@@ -0,0 +1,50 @@
+package herald
+
+import (
+	"time"
+)
+
+// Clock provides the current time and timers. A fake implementation can be
+// provided in tests to control time-dependent behavior without waiting.
+type Clock interface {
+
+	// Now returns the current time.
+	Now() time.Time
+
+	// After returns a channel that receives the current time once the
+	// duration has elapsed.
+	After(d time.Duration) <-chan time.Time
+
+	// NewTicker returns a channel that receives the current time each time
+	// the duration elapses and a function that stops the ticker.
+	NewTicker(d time.Duration) (<-chan time.Time, func())
+}
+
+// systemClock implements Clock using the time package.
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+func (systemClock) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
+}
+
+func (systemClock) NewTicker(d time.Duration) (<-chan time.Time, func()) {
+	t := time.NewTicker(d)
+	return t.C, t.Stop
+}
+
+// clockOrSystem returns the clock or the system clock if it is nil.
+func clockOrSystem(c Clock) Clock {
+	if c == nil {
+		return systemClock{}
+	}
+	return c
+}
+
+// clock returns the clock used by the Herald.
+func (h *Herald) clock() Clock {
+	return clockOrSystem(h.Clock)
+}
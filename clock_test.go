@@ -0,0 +1,128 @@
+package herald
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+type fakeTimer struct {
+	when   time.Time
+	period time.Duration
+	c      chan time.Time
+}
+
+// fakeClock is a Clock that only advances when Advance is called.
+type fakeClock struct {
+	mutex  sync.Mutex
+	now    time.Time
+	timers []*fakeTimer
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{now: time.Unix(0, 0)}
+}
+
+func (f *fakeClock) Now() time.Time {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return f.now
+}
+
+func (f *fakeClock) add(d, period time.Duration) *fakeTimer {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	t := &fakeTimer{
+		when:   f.now.Add(d),
+		period: period,
+		c:      make(chan time.Time, 1),
+	}
+	f.timers = append(f.timers, t)
+	return t
+}
+
+func (f *fakeClock) After(d time.Duration) <-chan time.Time {
+	return f.add(d, 0).c
+}
+
+func (f *fakeClock) NewTicker(d time.Duration) (<-chan time.Time, func()) {
+	t := f.add(d, d)
+	return t.c, func() {
+		f.mutex.Lock()
+		defer f.mutex.Unlock()
+		t.period = 0
+		t.when = time.Time{}
+	}
+}
+
+// Advance moves the clock forward, firing any timers that expire.
+func (f *fakeClock) Advance(d time.Duration) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.now = f.now.Add(d)
+	var timers []*fakeTimer
+	for _, t := range f.timers {
+		if t.when.IsZero() {
+			continue
+		}
+		if !t.when.After(f.now) {
+			select {
+			case t.c <- f.now:
+			default:
+			}
+			if t.period == 0 {
+				continue
+			}
+			t.when = f.now.Add(t.period)
+		}
+		timers = append(timers, t)
+	}
+	f.timers = timers
+}
+
+func TestTicketIssuerClock(t *testing.T) {
+
+	// Create an issuer with a fake clock and issue a ticket
+	var (
+		c  = newFakeClock()
+		i  = &TicketIssuer{Clock: c}
+		id = i.Issue(nil)
+		r  = httptest.NewRequest(http.MethodGet, "/?ticket="+id, nil)
+	)
+
+	// Ensure the ticket expires once the clock passes the TTL
+	c.Advance(defaultTicketTTL + time.Second)
+	if _, err := i.Redeem(r); !errors.Is(err, ErrInvalidTicket) {
+		t.Fatalf("%v != %v", err, ErrInvalidTicket)
+	}
+}
+
+func TestHeraldClock(t *testing.T) {
+
+	// Create the server with a fake clock and a client
+	var (
+		clock = newFakeClock()
+		s     = newTestServer(func(h *Herald) {
+			h.Clock = clock
+		})
+		c  = newTestClient(t, s)
+		m1 = newTestMessage(t, messageType1)
+		m2 = newTestMessage(t, messageType2)
+	)
+	defer s.herald.Close()
+
+	// Send a message whose deadline has only passed according to the fake
+	// clock and ensure it is discarded
+	m1.Deadline = clock.Now().Add(time.Minute)
+	clock.Advance(time.Hour)
+	s.herald.Send(m1, nil)
+	s.herald.Send(m2, nil)
+	c.receive(t, s, m2)
+	if n := s.herald.ExpiredCount(); n != 1 {
+		t.Fatalf("%d != 1", n)
+	}
+	c.close(s)
+}
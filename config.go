@@ -0,0 +1,53 @@
+package herald
+
+import (
+	"time"
+)
+
+// ConfigMessageType is the type of the message sent to clients to adjust
+// their behavior at runtime. The data of the message contains
+// "heartbeat_ms", "max_reconnect_backoff_ms", and "flags", each of which is
+// omitted if not set.
+const ConfigMessageType = "herald.config"
+
+// ClientConfig contains settings that clients should apply when received.
+// Zero values indicate that the client should keep its current setting.
+type ClientConfig struct {
+
+	// HeartbeatInterval specifies how often the client should send a
+	// heartbeat.
+	HeartbeatInterval time.Duration
+
+	// MaxReconnectBackoff specifies the longest the client should wait
+	// between attempts to reconnect.
+	MaxReconnectBackoff time.Duration
+
+	// Flags enables or disables features in the client.
+	Flags map[string]bool
+}
+
+type configMessage struct {
+	HeartbeatMS           int64           `json:"heartbeat_ms,omitempty"`
+	MaxReconnectBackoffMS int64           `json:"max_reconnect_backoff_ms,omitempty"`
+	Flags                 map[string]bool `json:"flags,omitempty"`
+}
+
+// NewConfigMessage creates a new message of type ConfigMessageType with the
+// specified settings.
+func NewConfigMessage(config *ClientConfig) (*Message, error) {
+	return NewMessage(ConfigMessageType, &configMessage{
+		HeartbeatMS:           config.HeartbeatInterval.Milliseconds(),
+		MaxReconnectBackoffMS: config.MaxReconnectBackoff.Milliseconds(),
+		Flags:                 config.Flags,
+	})
+}
+
+// SendConfig sends the settings to the specified clients or all clients if
+// nil. ErrClosed is returned if the Herald is shutting down.
+func (h *Herald) SendConfig(config *ClientConfig, clients []*Client) error {
+	m, err := NewConfigMessage(config)
+	if err != nil {
+		return err
+	}
+	return h.Send(m, clients)
+}
@@ -0,0 +1,36 @@
+package herald
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestHeraldSendConfig(t *testing.T) {
+
+	// Create the server and a client
+	s := newTestServer()
+	defer s.herald.Close()
+	c := newTestClient(t, s)
+
+	// Send a configuration and ensure that it is received
+	if err := s.herald.SendConfig(&ClientConfig{
+		HeartbeatInterval: 30 * time.Second,
+		Flags:             map[string]bool{"compact": true},
+	}, nil); err != nil {
+		t.Fatal(err)
+	}
+	m := c.receive(t, s, &Message{Type: ConfigMessageType})
+	v := &configMessage{}
+	if err := json.Unmarshal(m.Data, v); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(v, &configMessage{
+		HeartbeatMS: 30000,
+		Flags:       map[string]bool{"compact": true},
+	}) {
+		t.Fatalf("unexpected configuration: %+v", v)
+	}
+	c.close(s)
+}
@@ -0,0 +1,56 @@
+package herald
+
+import (
+	"errors"
+	"net/http"
+)
+
+// ConnectError rejects a connection with a specific HTTP status code. It can
+// be returned by functions registered with UseConnect().
+type ConnectError struct {
+
+	// StatusCode is the HTTP status code sent in the response.
+	StatusCode int
+
+	// Code is sent as the code of the ErrorMessage in the response. If
+	// empty, a code is chosen based on StatusCode.
+	Code string
+
+	// Message is sent as the description of the ErrorMessage in the
+	// response. If empty, the text for StatusCode is used.
+	Message string
+}
+
+// Error returns the message sent to the client.
+func (e *ConnectError) Error() string {
+	if e.Message == "" {
+		return http.StatusText(e.StatusCode)
+	}
+	return e.Message
+}
+
+// UseConnect adds a function that is invoked for each new connection before
+// it is upgraded. The functions are invoked in the order they were added and
+// if any of them returns an error, the connection is rejected. A ConnectError
+// can be returned to control the response; all other errors result in a 403
+// Forbidden response. The response is written by the function provided to
+// SetUpgradeError(). This method must be called before clients are added.
+func (h *Herald) UseConnect(fn func(r *http.Request) error) {
+	h.connectFuncs = append(h.connectFuncs, fn)
+}
+
+// checkConnect invokes each of the connect functions, writing an error
+// response and returning the error if any of them fail.
+func (h *Herald) checkConnect(w http.ResponseWriter, r *http.Request) error {
+	for _, fn := range h.connectFuncs {
+		if err := fn(r); err != nil {
+			var connectErr *ConnectError
+			if !errors.As(err, &connectErr) {
+				connectErr = &ConnectError{StatusCode: http.StatusForbidden}
+			}
+			h.reject(w, r, connectErr.StatusCode, connectErr)
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,61 @@
+package herald
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestUseConnect(t *testing.T) {
+
+	// Create the server with two connect functions, the first of which rejects
+	// requests without an API key and the second of which rejects everything
+	s := newTestServer(func(h *Herald) {
+		h.UseConnect(func(r *http.Request) error {
+			if r.Header.Get("X-Api-Key") == "" {
+				return &ConnectError{
+					StatusCode: http.StatusUnauthorized,
+					Code:       "missing_key",
+				}
+			}
+			return nil
+		})
+		h.UseConnect(func(r *http.Request) error {
+			return errors.New("maintenance")
+		})
+	})
+	defer s.herald.Close()
+
+	// Ensure that each request is rejected by the correct function
+	for i, v := range []struct {
+		key    string
+		status int
+		code   string
+	}{
+		{key: "", status: http.StatusUnauthorized, code: "missing_key"},
+		{key: "key", status: http.StatusForbidden, code: ErrorCodeForbidden},
+	} {
+		var (
+			w = httptest.NewRecorder()
+			r = httptest.NewRequest(http.MethodGet, "/", nil)
+		)
+		if v.key != "" {
+			r.Header.Set("X-Api-Key", v.key)
+		}
+		if _, err := s.herald.AddClient(w, r, nil); err == nil {
+			t.Fatalf("%d: request was not rejected", i)
+		}
+		if w.Code != v.status {
+			t.Fatalf("%d: %d != %d", i, w.Code, v.status)
+		}
+		e := &ErrorMessage{}
+		if err := json.NewDecoder(w.Body).Decode(e); err != nil {
+			t.Fatal(err)
+		}
+		if e.Code != v.code {
+			t.Fatalf("%d: %s != %s", i, e.Code, v.code)
+		}
+	}
+}
@@ -0,0 +1,49 @@
+package herald
+
+import (
+	"errors"
+	"time"
+)
+
+var (
+	// ErrHandlerTimeout indicates that MessageHandler did not finish
+	// processing a message before HandlerTimeout elapsed.
+	ErrHandlerTimeout = errors.New("message handler timed out")
+)
+
+// DeadLetter stores information about a message that could not be processed.
+type DeadLetter struct {
+
+	// Message is the original message received from the client.
+	Message *Message
+
+	// Client is the client that sent the message.
+	Client *Client
+
+	// Attributes contains the attributes attached to Client when the message
+	// was rejected.
+	Attributes map[string]string
+
+	// Attempts indicates how many times processing the message was attempted.
+	// It is zero for messages that were rejected before reaching a handler.
+	Attempts int
+
+	// Err describes the reason the message could not be processed.
+	Err error
+
+	// Time indicates when the message was rejected.
+	Time time.Time
+}
+
+func (h *Herald) deadLetter(m *Message, c *Client, attempts int, err error) {
+	if h.DeadLetterHandler != nil {
+		h.DeadLetterHandler(&DeadLetter{
+			Message:    m,
+			Client:     c,
+			Attributes: c.Attributes(),
+			Attempts:   attempts,
+			Err:        err,
+			Time:       h.clock().Now(),
+		})
+	}
+}
@@ -0,0 +1,81 @@
+package herald
+
+// dedupWindow is the number of sequence numbers from each instance that are
+// remembered to detect duplicate envelopes. Envelopes that are older than
+// this compared to the newest envelope from the same instance are treated
+// as duplicates.
+const dedupWindow = 1024
+
+// seqWindow remembers the sequence numbers recently received from an
+// instance.
+type seqWindow struct {
+	max  uint64
+	seen map[uint64]struct{}
+}
+
+// accept records the sequence number, returning false if it was already
+// received or is too old to tell.
+func (w *seqWindow) accept(seq uint64) bool {
+	if seq+dedupWindow <= w.max {
+		return false
+	}
+	if _, ok := w.seen[seq]; ok {
+		return false
+	}
+	w.seen[seq] = struct{}{}
+	if seq > w.max {
+		w.max = seq
+	}
+	if len(w.seen) > 2*dedupWindow {
+		for s := range w.seen {
+			if s+dedupWindow <= w.max {
+				delete(w.seen, s)
+			}
+		}
+	}
+	return true
+}
+
+// nextSeq returns the sequence number for the next envelope published by
+// this instance.
+func (s *backplaneState) nextSeq() uint64 {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.seq++
+	return s.seq
+}
+
+// acceptEnvelope determines whether an envelope has not been received
+// before. This prevents messages from being delivered more than once when
+// envelopes loop back through multiple bridges or backplanes. Envelopes
+// without a sequence number are always accepted.
+func (s *backplaneState) acceptEnvelope(e *backplaneEnvelope) bool {
+	if e.Seq == 0 {
+		return true
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.windows == nil {
+		s.windows = make(map[string]*seqWindow)
+	}
+	w, ok := s.windows[e.Origin]
+	if !ok {
+		w = &seqWindow{seen: make(map[uint64]struct{})}
+		s.windows[e.Origin] = w
+	}
+	return w.accept(e.Seq)
+}
+
+// pruneWindows forgets the sequence numbers of instances that are no longer
+// members. This must be invoked with the mutex held.
+func (s *backplaneState) pruneWindows(members []string) {
+	current := make(map[string]struct{}, len(members))
+	for _, m := range members {
+		current[m] = struct{}{}
+	}
+	for origin := range s.windows {
+		if _, ok := current[origin]; !ok {
+			delete(s.windows, origin)
+		}
+	}
+}
@@ -0,0 +1,169 @@
+package herald
+
+// DeliveryStatus indicates the outcome of queueing a message for a client.
+type DeliveryStatus int
+
+const (
+
+	// StatusQueued indicates that the message was added to the client's
+	// queue and will be written to the socket.
+	StatusQueued DeliveryStatus = iota
+
+	// StatusDropped indicates that the client's queue was full. The message
+	// was discarded and, unless it was sent with SendEphemeral, the client
+	// is being disconnected.
+	StatusDropped
+
+	// StatusGone indicates that the client had already disconnected.
+	StatusGone
+)
+
+// String returns a human-readable name for the status.
+func (s DeliveryStatus) String() string {
+	switch s {
+	case StatusQueued:
+		return "queued"
+	case StatusDropped:
+		return "dropped"
+	case StatusGone:
+		return "gone"
+	default:
+		return "unknown"
+	}
+}
+
+// SendResult indicates the outcome of sending a message to a single client.
+type SendResult struct {
+	Client *Client
+	Status DeliveryStatus
+}
+
+// enqueue attempts to add the messages to the client's write queue. Either
+// all of the messages are queued or none of them are. If the queue does not
+// have room for all of the messages or queueing them would exceed
+// MaxQueuedBytes and EvictionPolicy does not free enough memory, the client
+// is disconnected.
+func (c *Client) enqueue(entries []*outgoing) DeliveryStatus {
+	key := ""
+	if len(entries) == 1 {
+		key = c.coalesceKey(entries[0].message)
+	}
+	reason := "memory limit"
+	status := StatusDropped
+	if !c.herald.exceedsMemory(entries) || c.herald.evictFor(c, entries) {
+		reason = "queue full"
+		status = c.queue.push(entries, key)
+	}
+	if status == StatusDropped {
+		for _, o := range entries {
+			c.herald.recordDrop(c, o.message, reason)
+		}
+		c.conn.Close()
+	}
+	return status
+}
+
+// deliver queues the messages for each of the target clients. If the
+// transformations discard every message, nothing is delivered.
+func (h *Herald) deliver(p *sendParams) {
+	if !p.remote {
+		p.messages = h.transformMessages(p.messages)
+	}
+	if len(p.messages) == 0 {
+		if p.receipt != nil {
+			p.receipt.start(0)
+		}
+		if p.resultChan != nil {
+			p.resultChan <- nil
+		}
+		return
+	}
+	p.messages = h.signMessages(p.messages)
+	if p.group != "" {
+		p.clients = append([]*Client{}, h.groups[p.group]...)
+		if h.backplane != nil && !p.remote {
+			h.publish(broadcastChannel, &backplaneEnvelope{
+				Messages: p.messages,
+				Group:    p.group,
+			})
+		}
+	} else if p.room != "" {
+		p.clients = h.RoomClients(p.room)
+		if h.backplane != nil && !p.remote {
+			h.publish(broadcastChannel, &backplaneEnvelope{
+				Messages: p.messages,
+				Room:     p.room,
+			})
+		}
+	} else if p.except != nil {
+		p.clients = []*Client{}
+		for _, c := range h.clients {
+			if !p.except(c) {
+				p.clients = append(p.clients, c)
+			}
+		}
+	} else if p.clients == nil {
+		p.clients = h.clients
+		if !p.ephemeral {
+			for _, m := range p.messages {
+				h.retain(m)
+			}
+		}
+		if h.backplane != nil && !p.remote {
+			h.publish(broadcastChannel, &backplaneEnvelope{
+				Messages:  p.messages,
+				Ephemeral: p.ephemeral,
+			})
+		}
+	}
+	if p.receipt != nil {
+		p.receipt.start(len(p.clients) * len(p.messages))
+	}
+	var results []*SendResult
+	for _, c := range p.clients {
+		entries := make([]*outgoing, len(p.messages))
+		for i, m := range p.messages {
+			entries[i] = &outgoing{
+				message: m,
+				receipt: p.receipt,
+				client:  c,
+			}
+		}
+		var status DeliveryStatus
+		if p.ephemeral {
+			status = c.enqueueEphemeral(entries[0])
+		} else {
+			status = c.enqueue(entries)
+		}
+		if status != StatusQueued && p.receipt != nil {
+			for range entries {
+				p.receipt.complete(c, ErrNotDelivered)
+			}
+		}
+		if p.resultChan != nil {
+			results = append(results, &SendResult{
+				Client: c,
+				Status: status,
+			})
+		}
+	}
+	if p.resultChan != nil {
+		p.resultChan <- results
+	}
+}
+
+// SendWithResults works like Send but also returns a channel that receives
+// the outcome for each of the targeted clients once the message has been
+// queued. The channel is buffered and receives exactly one value, which is
+// nil if the Herald is shutting down.
+func (h *Herald) SendWithResults(message *Message, clients []*Client) <-chan []*SendResult {
+	resultChan := make(chan []*SendResult, 1)
+	if err := h.queueSend(&sendParams{
+		messages:   []*Message{message},
+		clients:    clients,
+		resultChan: resultChan,
+	}); err != nil {
+		resultChan <- nil
+	}
+	return resultChan
+}
@@ -0,0 +1,121 @@
+package herald
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"runtime"
+	"runtime/pprof"
+	"time"
+)
+
+// maxDrops is the number of dropped messages remembered for diagnostics.
+const maxDrops = 100
+
+// Drop describes a message that was discarded for a client.
+type Drop struct {
+	Time   time.Time `json:"time"`
+	Type   string    `json:"type"`
+	Group  string    `json:"group,omitempty"`
+	Reason string    `json:"reason"`
+}
+
+// recordDrop remembers that the message was discarded for the client,
+// keeping only the most recent drops.
+func (h *Herald) recordDrop(c *Client, m *Message, reason string) {
+	h.dropMutex.Lock()
+	defer h.dropMutex.Unlock()
+	if len(h.drops) == maxDrops {
+		h.drops = h.drops[1:]
+	}
+	h.drops = append(h.drops, &Drop{
+		Time:   h.clock().Now(),
+		Type:   m.Type,
+		Group:  c.group,
+		Reason: reason,
+	})
+}
+
+// RecentDrops returns the most recently dropped messages, oldest first.
+func (h *Herald) RecentDrops() []*Drop {
+	h.dropMutex.Lock()
+	defer h.dropMutex.Unlock()
+	return append([]*Drop(nil), h.drops...)
+}
+
+type diagnosticsClient struct {
+	Group        string            `json:"group,omitempty"`
+	QueueDepth   int               `json:"queue_depth"`
+	Attributes   map[string]string `json:"attributes,omitempty"`
+	Capabilities *Capabilities     `json:"capabilities"`
+}
+
+type diagnosticsConfig struct {
+	HandlerTimeout     time.Duration `json:"handler_timeout"`
+	HandshakeTimeout   time.Duration `json:"handshake_timeout"`
+	ClientLinger       time.Duration `json:"client_linger"`
+	ClientThrottle     *Throttle     `json:"client_throttle"`
+	AcceptRate         float64       `json:"accept_rate"`
+	AcceptBurst        int           `json:"accept_burst"`
+	ShutdownTimeout    time.Duration `json:"shutdown_timeout"`
+	MembershipInterval time.Duration `json:"membership_interval"`
+	RequireReady       bool          `json:"require_ready"`
+	Backplane          bool          `json:"backplane"`
+}
+
+type diagnostics struct {
+	Time        time.Time            `json:"time"`
+	ID          string               `json:"id"`
+	Maintenance bool                 `json:"maintenance"`
+	Clients     []*diagnosticsClient `json:"clients"`
+	Expired     uint64               `json:"expired"`
+	Drops       []*Drop              `json:"drops"`
+	Config      *diagnosticsConfig   `json:"config"`
+	Goroutines  int                  `json:"goroutines"`
+	Stacks      string               `json:"stacks"`
+}
+
+// DiagnosticsHandler creates an http.Handler that responds with a JSON
+// bundle for attaching to bug reports. It contains the connected clients and
+// their queue depths, the most recently dropped messages, the configuration,
+// and a dump of all goroutine stacks. The handler exposes internal details
+// and must be protected from public access.
+func (h *Herald) DiagnosticsHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		d := &diagnostics{
+			Time:        h.clock().Now(),
+			ID:          h.id,
+			Maintenance: h.Maintenance() != nil,
+			Clients:     []*diagnosticsClient{},
+			Expired:     h.ExpiredCount(),
+			Drops:       h.RecentDrops(),
+			Config: &diagnosticsConfig{
+				HandlerTimeout:     h.HandlerTimeout,
+				HandshakeTimeout:   h.HandshakeTimeout,
+				ClientLinger:       h.ClientLinger,
+				ClientThrottle:     h.ClientThrottle,
+				AcceptRate:         h.AcceptRate,
+				AcceptBurst:        h.AcceptBurst,
+				ShutdownTimeout:    h.ShutdownTimeout,
+				MembershipInterval: h.MembershipInterval,
+				RequireReady:       h.RequireReady,
+				Backplane:          h.backplane != nil,
+			},
+			Goroutines: runtime.NumGoroutine(),
+		}
+		h.ForEachClient(func(c *Client) {
+			d.Clients = append(d.Clients, &diagnosticsClient{
+				Group:        c.group,
+				QueueDepth:   c.queue.len(),
+				Attributes:   c.Attributes(),
+				Capabilities: c.capabilities,
+			})
+		})
+		b := &bytes.Buffer{}
+		pprof.Lookup("goroutine").WriteTo(b, 2)
+		d.Stacks = b.String()
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Content-Disposition", `attachment; filename="herald-diagnostics.json"`)
+		json.NewEncoder(w).Encode(d)
+	})
+}
@@ -0,0 +1,42 @@
+package herald
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestDiagnosticsHandler(t *testing.T) {
+
+	// Create the server, a client, and a message that expires immediately
+	var (
+		s = newTestServer()
+		c = newTestClient(t, s)
+		m = newTestMessage(t, messageType1)
+	)
+	defer s.herald.Close()
+	m.Deadline = time.Now().Add(-time.Second)
+	<-s.herald.SendWithResults(m, nil)
+	c.client.Flush(context.Background())
+
+	// Request the bundle and ensure it includes the client and the drop
+	w := httptest.NewRecorder()
+	s.herald.DiagnosticsHandler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	d := &diagnostics{}
+	if err := json.NewDecoder(w.Body).Decode(d); err != nil {
+		t.Fatal(err)
+	}
+	if len(d.Clients) != 1 {
+		t.Fatalf("%d != 1", len(d.Clients))
+	}
+	if len(d.Drops) != 1 || d.Drops[0].Reason != "expired" {
+		t.Fatalf("unexpected drops: %v", d.Drops)
+	}
+	if d.Stacks == "" {
+		t.Fatal("stacks missing")
+	}
+	c.close(s)
+}
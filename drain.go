@@ -0,0 +1,48 @@
+package herald
+
+import (
+	"time"
+)
+
+// DrainMessageType is the type of the message sent to clients when the
+// Herald begins shutting down and DrainNotice is set. The data of the message
+// contains "backoff_ms", the number of milliseconds to wait before
+// reconnecting, and "endpoint", the URL to reconnect to, if provided.
+const DrainMessageType = "herald.drain"
+
+// DrainNotice informs clients that they are about to be disconnected because
+// the server is shutting down and suggests how they should reconnect.
+type DrainNotice struct {
+
+	// Backoff suggests how long clients should wait before reconnecting.
+	Backoff time.Duration
+
+	// Endpoint optionally provides an alternative URL for clients to
+	// reconnect to.
+	Endpoint string
+}
+
+type drainMessage struct {
+	BackoffMS int64  `json:"backoff_ms"`
+	Endpoint  string `json:"endpoint,omitempty"`
+}
+
+// sendDrainNotice queues the drain notice for all clients, if one was
+// provided. This is invoked by the run loop after all other pending messages
+// are queued so that it is the last message each client receives.
+func (h *Herald) sendDrainNotice() {
+	if h.DrainNotice == nil || len(h.clients) == 0 {
+		return
+	}
+	m, err := NewMessage(DrainMessageType, &drainMessage{
+		BackoffMS: h.DrainNotice.Backoff.Milliseconds(),
+		Endpoint:  h.DrainNotice.Endpoint,
+	})
+	if err != nil {
+		return
+	}
+	h.deliver(&sendParams{
+		messages: []*Message{m},
+		clients:  h.clients,
+	})
+}
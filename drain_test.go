@@ -0,0 +1,40 @@
+package herald
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestHeraldDrainNotice(t *testing.T) {
+
+	// Create the server with a drain notice and a client
+	var (
+		s = newTestServer(func(h *Herald) {
+			h.DrainNotice = &DrainNotice{
+				Backoff:  time.Second,
+				Endpoint: "wss://example.com",
+			}
+		})
+		c = newTestClient(t, s)
+		m = newTestMessage(t, messageType1)
+	)
+
+	// Queue a message and shut down
+	s.herald.Send(m, nil)
+	s.clientRemovedWG.Add(1)
+	s.herald.Shutdown(context.Background())
+
+	// Ensure the notice is received after the message
+	c.receive(t, s, m)
+	n := c.receive(t, s, &Message{Type: DrainMessageType})
+	v := &drainMessage{}
+	if err := json.Unmarshal(n.Data, v); err != nil {
+		t.Fatal(err)
+	}
+	if v.BackoffMS != 1000 || v.Endpoint != "wss://example.com" {
+		t.Fatalf("unexpected notice: %+v", v)
+	}
+	c.verifyDisconnected(t)
+}
@@ -0,0 +1,33 @@
+package herald
+
+// SendEphemeral sends a transient message, such as a typing indicator or a
+// cursor position, to the specified clients or all clients if nil. Ephemeral
+// messages are never retained and replace any queued message with the same
+// coalescing key, which defaults to the message type. If a client's queue is
+// full or queueing the message would exceed MaxQueuedBytes, the message is
+// discarded for that client without disconnecting it or evicting other
+// clients. ErrClosed is returned if the Herald is shutting down.
+func (h *Herald) SendEphemeral(message *Message, clients []*Client) error {
+	return h.queueSend(&sendParams{
+		messages:  []*Message{message},
+		clients:   clients,
+		ephemeral: true,
+	})
+}
+
+// enqueueEphemeral attempts to add an ephemeral message to the client's write
+// queue. The message is discarded if there is no room for it.
+func (c *Client) enqueueEphemeral(o *outgoing) DeliveryStatus {
+	key := c.coalesceKey(o.message)
+	if key == "" {
+		key = o.message.Type
+	}
+	status := StatusDropped
+	if !c.herald.exceedsMemory([]*outgoing{o}) {
+		status = c.queue.push([]*outgoing{o}, key)
+	}
+	if status == StatusDropped {
+		c.herald.recordDrop(c, o.message, "ephemeral")
+	}
+	return status
+}
@@ -0,0 +1,78 @@
+package herald
+
+import (
+	"testing"
+	"time"
+)
+
+func TestHeraldSendEphemeral(t *testing.T) {
+
+	// Create the server and a client
+	s := newTestServer()
+	defer s.herald.Close()
+	c := newTestClient(t, s)
+
+	// Broadcast an ephemeral message marked for retention and ensure that it
+	// is delivered but not retained
+	m := newTestMessage(t, messageType1)
+	m.Retain = true
+	if err := s.herald.SendEphemeral(m, nil); err != nil {
+		t.Fatal(err)
+	}
+	c.receive(t, s, m)
+	if s.herald.Retained(messageType1) != nil {
+		t.Fatal("ephemeral message was retained")
+	}
+	c.close(s)
+}
+
+func TestHeraldSendEphemeralDropped(t *testing.T) {
+
+	// Create the server with a limit too small for any message
+	s := newTestServer(func(h *Herald) {
+		h.MaxQueuedBytes = 1
+	})
+	defer s.herald.Close()
+	c := newTestClient(t, s)
+
+	// Send an ephemeral message and wait for it to be dropped
+	if err := s.herald.SendEphemeral(newTestMessage(t, messageType1), nil); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; len(s.herald.RecentDrops()) == 0; i++ {
+		if i == 100 {
+			t.Fatal("message was not dropped")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if drops := s.herald.RecentDrops(); drops[0].Reason != "ephemeral" {
+		t.Fatalf("%s != ephemeral", drops[0].Reason)
+	}
+
+	// Ensure that the client is still connected
+	c.send(t, s, newTestMessage(t, messageType1))
+	c.close(s)
+}
+
+func TestHeraldSendEphemeralDiscarded(t *testing.T) {
+
+	// Create the server with a transformation that discards one type
+	s := newTestServer(func(h *Herald) {
+		h.AddTransform(messageType2, func(m *Message) (*Message, error) {
+			return nil, nil
+		})
+	})
+	defer s.herald.Close()
+	c := newTestClient(t, s)
+
+	// Send a discarded ephemeral message followed by another message and
+	// ensure that only the second one arrives
+	if err := s.herald.SendEphemeral(newTestMessage(t, messageType2), nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.herald.SendEphemeral(newTestMessage(t, messageType1), nil); err != nil {
+		t.Fatal(err)
+	}
+	c.receive(t, s, &Message{Type: messageType1})
+	c.close(s)
+}
@@ -0,0 +1,159 @@
+package herald
+
+import (
+	"errors"
+	"fmt"
+	"runtime/debug"
+)
+
+var (
+	// ErrHandlerPanic indicates that MessageHandler panicked while processing
+	// a message.
+	ErrHandlerPanic = errors.New("message handler panicked")
+
+	// ErrUnsupportedMessageType indicates that a client sent a WebSocket
+	// message that was not a text message.
+	ErrUnsupportedMessageType = errors.New("unsupported message type")
+
+	// ErrHandshakeExpected indicates that the first message sent by a client
+	// did not have the type specified by HandshakeType.
+	ErrHandshakeExpected = errors.New("handshake message expected")
+
+	// ErrMaintenance indicates that a connection was rejected because the
+	// Herald is in maintenance mode.
+	ErrMaintenance = errors.New("maintenance mode enabled")
+
+	// ErrUnknownType indicates that a client sent a message with a type for
+	// which no handler was registered.
+	ErrUnknownType = errors.New("unknown message type")
+)
+
+// ErrorKind indicates the category of an error reported to ErrorHandler.
+type ErrorKind int
+
+const (
+
+	// ErrorPanic indicates that a message handler panicked.
+	ErrorPanic ErrorKind = iota
+
+	// ErrorWrite indicates that a message could not be written to a client.
+	ErrorWrite
+
+	// ErrorProtocol indicates that a client sent a message that could not be
+	// decoded.
+	ErrorProtocol
+
+	// ErrorBackplane indicates that a message could not be exchanged with the
+	// backplane. Client is nil for errors of this kind.
+	ErrorBackplane
+
+	// ErrorTransform indicates that a transformation registered with
+	// AddTransform failed. The message is not sent and Client is nil for
+	// errors of this kind.
+	ErrorTransform
+
+	// ErrorSession indicates that a session could not be loaded from or
+	// saved to SessionStore or that it belongs to a different identity.
+	// Client is nil if the session could not be resumed.
+	ErrorSession
+
+	// ErrorResolve indicates that IPResolver failed. The connection is
+	// accepted without IP information and Client is nil for errors of this
+	// kind.
+	ErrorResolve
+
+	// ErrorSnapshot indicates that the snapshot of a State could not be
+	// created when a client attempted to subscribe to it.
+	ErrorSnapshot
+)
+
+// String returns a human-readable name for the error kind.
+func (k ErrorKind) String() string {
+	switch k {
+	case ErrorPanic:
+		return "panic"
+	case ErrorWrite:
+		return "write"
+	case ErrorProtocol:
+		return "protocol"
+	case ErrorBackplane:
+		return "backplane"
+	case ErrorTransform:
+		return "transform"
+	case ErrorSession:
+		return "session"
+	case ErrorResolve:
+		return "resolve"
+	case ErrorSnapshot:
+		return "snapshot"
+	default:
+		return "unknown"
+	}
+}
+
+// ClientError provides information about an error that occurred while
+// exchanging messages with a client. It is intended to be forwarded to error
+// aggregation services.
+type ClientError struct {
+
+	// Kind indicates the category of the error.
+	Kind ErrorKind
+
+	// Client is the client the error applies to, if any.
+	Client *Client
+
+	// Attributes contains the attributes attached to Client when the error
+	// occurred.
+	Attributes map[string]string
+
+	// Message is the message being processed when the error occurred. This
+	// may be nil if the message could not be decoded.
+	Message *Message
+
+	// Err is the underlying error.
+	Err error
+
+	// Stack contains the stack trace for panics.
+	Stack []byte
+}
+
+// Error returns a description of the error.
+func (e *ClientError) Error() string {
+	return fmt.Sprintf("%s error: %s", e.Kind, e.Err)
+}
+
+// Unwrap returns the underlying error.
+func (e *ClientError) Unwrap() error {
+	return e.Err
+}
+
+// reportError passes the error to ErrorHandler if one was provided.
+func (h *Herald) reportError(e *ClientError) {
+	if h.ErrorHandler != nil {
+		if e.Client != nil {
+			e.Attributes = e.Client.Attributes()
+		}
+		h.ErrorHandler(e)
+	}
+}
+
+// callHandler invokes the handler, converting a panic into an error and
+// reporting it.
+func (h *Herald) callHandler(fn HandlerFunc, m *Message, c *Client) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%w: %v", ErrHandlerPanic, r)
+			h.reportError(&ClientError{
+				Kind:    ErrorPanic,
+				Client:  c,
+				Message: m,
+				Err:     err,
+				Stack:   debug.Stack(),
+			})
+		}
+	}()
+	h.withLabels(c, m, func() {
+		err = fn(m, c)
+	})
+	return
+}
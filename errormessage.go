@@ -0,0 +1,49 @@
+package herald
+
+// ErrorMessageType is the type of the messages sent to clients to report
+// errors. The data of the message is an ErrorMessage.
+const ErrorMessageType = "herald.error"
+
+// Codes used in error messages sent by the package. Applications may use
+// their own codes as well.
+const (
+	ErrorCodeInvalid      = "invalid"
+	ErrorCodeUnknownType  = "unknown_type"
+	ErrorCodeRateLimited  = "rate_limited"
+	ErrorCodeUnauthorized = "unauthorized"
+)
+
+// ErrorMessage describes an error reported to a client, such as a validation
+// failure or an unauthorized action.
+type ErrorMessage struct {
+
+	// Code identifies the kind of error in a machine-readable form.
+	Code string `json:"code"`
+
+	// Message is a human-readable description of the error.
+	Message string `json:"message"`
+
+	// RelatedID optionally identifies the request or message that caused
+	// the error so that the client can correlate them.
+	RelatedID string `json:"related_id,omitempty"`
+}
+
+// NewErrorMessage creates a new message of type ErrorMessageType with the
+// specified code and description.
+func NewErrorMessage(code, message, relatedID string) (*Message, error) {
+	return NewMessage(ErrorMessageType, &ErrorMessage{
+		Code:      code,
+		Message:   message,
+		RelatedID: relatedID,
+	})
+}
+
+// SendError sends an error message to the client. ErrClosed is returned if
+// the Herald is shutting down.
+func (h *Herald) SendError(client *Client, code, message, relatedID string) error {
+	m, err := NewErrorMessage(code, message, relatedID)
+	if err != nil {
+		return err
+	}
+	return h.Send(m, []*Client{client})
+}
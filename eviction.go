@@ -0,0 +1,150 @@
+package herald
+
+import (
+	"errors"
+	"time"
+)
+
+// CloseEvicted is the close code sent to clients that are disconnected by
+// the EvictionPolicy. The text of the close frame is "evicted".
+const CloseEvicted = 4503
+
+var (
+	// ErrEvicted indicates that a message was discarded because the client
+	// it was queued for was evicted.
+	ErrEvicted = errors.New("client evicted")
+)
+
+// EvictionPolicy chooses which client to disconnect when queueing a message
+// would exceed MaxQueuedBytes. It receives the connected clients that have
+// messages queued and have not already been evicted and returns the one to
+// evict. If it returns nil or the client the message is being queued for, the
+// message is dropped and that client is disconnected instead.
+type EvictionPolicy func(clients []*Client) *Client
+
+// EvictIdleLongest evicts the client that has gone the longest without
+// sending a message.
+func EvictIdleLongest(clients []*Client) *Client {
+	var (
+		victim *Client
+		oldest time.Time
+	)
+	for _, c := range clients {
+		if t := c.LastActive(); victim == nil || t.Before(oldest) {
+			victim, oldest = c, t
+		}
+	}
+	return victim
+}
+
+// EvictMostBacklogged evicts the client with the most bytes waiting to be
+// written to it.
+func EvictMostBacklogged(clients []*Client) *Client {
+	var (
+		victim *Client
+		most   int64
+	)
+	for _, c := range clients {
+		if n := c.QueuedBytes(); victim == nil || n > most {
+			victim, most = c, n
+		}
+	}
+	return victim
+}
+
+// EvictLowestPriority evicts the client with the lowest priority (see
+// Client.SetPriority).
+func EvictLowestPriority(clients []*Client) *Client {
+	var (
+		victim *Client
+		lowest int
+	)
+	for _, c := range clients {
+		if p := c.Priority(); victim == nil || p < lowest {
+			victim, lowest = c, p
+		}
+	}
+	return victim
+}
+
+// evictFor evicts clients according to EvictionPolicy until the entries can
+// be queued for the client without exceeding MaxQueuedBytes. Only clients
+// with messages queued are considered, since evicting any other client frees
+// nothing. False is returned if the client itself should be disconnected
+// instead.
+func (h *Herald) evictFor(c *Client, entries []*outgoing) bool {
+	if h.EvictionPolicy == nil {
+		return false
+	}
+	evicted := make(map[*Client]struct{})
+	for h.exceedsMemory(entries) {
+		candidates := make([]*Client, 0, len(h.clients))
+		for _, v := range h.clients {
+			if _, ok := evicted[v]; !ok && v.QueuedBytes() > 0 {
+				candidates = append(candidates, v)
+			}
+		}
+		if len(candidates) == 0 {
+			return false
+		}
+		victim := h.EvictionPolicy(candidates)
+		if _, ok := evicted[victim]; victim == nil || victim == c || ok {
+			return false
+		}
+		n := h.QueuedBytes()
+		victim.evict()
+		evicted[victim] = struct{}{}
+		if h.QueuedBytes() >= n {
+			return false
+		}
+	}
+	return true
+}
+
+// evict discards the messages queued for the client, freeing their memory
+// immediately, and disconnects it with CloseEvicted. The close frame is sent
+// from a separate goroutine since the client's writer may be blocked on the
+// connection.
+func (c *Client) evict() {
+	c.mutex.Lock()
+	c.closeCode, c.closeText = CloseEvicted, "evicted"
+	c.mutex.Unlock()
+	c.queue.clear(ErrEvicted)
+	go c.closeWithCode(CloseEvicted, "evicted")
+}
+
+// SetPriority sets the priority of the client, which is used by
+// EvictLowestPriority. Clients have a priority of zero by default.
+func (c *Client) SetPriority(priority int) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.priority = priority
+}
+
+// Priority returns the priority of the client.
+func (c *Client) Priority() int {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.priority
+}
+
+// LastActive returns the time at which the client last sent a message or,
+// if it has not sent any, the time at which it connected.
+func (c *Client) LastActive() time.Time {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.lastActive
+}
+
+// touch records that the client sent a message.
+func (c *Client) touch() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.lastActive = c.herald.clock().Now()
+}
+
+// QueuedBytes returns the approximate number of bytes of messages waiting to
+// be written to the client.
+func (c *Client) QueuedBytes() int64 {
+	return c.queue.size()
+}
@@ -0,0 +1,127 @@
+package herald
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestEvictionPolicies(t *testing.T) {
+	var (
+		now = time.Now()
+		c1  = &Client{queue: newQueue(nil), lastActive: now, priority: 2}
+		c2  = &Client{queue: newQueue(nil), lastActive: now.Add(-time.Minute), priority: 1}
+		c3  = &Client{queue: newQueue(nil), lastActive: now, priority: 3}
+	)
+	c3.queue.push([]*outgoing{{message: &Message{Type: messageType1}}}, "")
+	clients := []*Client{c1, c2, c3}
+	if c := EvictIdleLongest(clients); c != c2 {
+		t.Fatal("idle client was not chosen")
+	}
+	if c := EvictMostBacklogged(clients); c != c3 {
+		t.Fatal("backlogged client was not chosen")
+	}
+	if c := EvictLowestPriority(clients); c != c2 {
+		t.Fatal("low priority client was not chosen")
+	}
+}
+
+func TestHeraldEvictionPolicy(t *testing.T) {
+
+	// Create the server with room for a few small messages
+	s := newTestServer(func(h *Herald) {
+		h.MaxQueuedBytes = 30
+		h.EvictionPolicy = EvictLowestPriority
+	})
+	defer s.herald.Close()
+
+	// Create a low priority client that is throttled so that messages
+	// remain in its queue and a high priority client
+	var (
+		c1 = newTestClient(t, s)
+		c2 = newTestClient(t, s)
+	)
+	c1.client.SetThrottle(&Throttle{MessagesPerSecond: 1})
+	c2.client.SetPriority(1)
+	for i := 0; i < 3; i++ {
+		if err := s.herald.Send(newTestMessage(t, messageType1), []*Client{c1.client}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Send a large message to the second client and ensure that the first
+	// is evicted to make room for it
+	m, err := NewMessage(messageType2, strings.Repeat("x", 20))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.clientRemovedWG.Add(1)
+	if err := s.herald.Send(m, []*Client{c2.client}); err != nil {
+		t.Fatal(err)
+	}
+	c2.receive(t, s, m)
+	c1.conn.SetReadDeadline(time.Now().Add(receiveTimeout))
+	for {
+		_, p, err := c1.conn.ReadMessage()
+		if err != nil {
+			if !websocket.IsCloseError(err, CloseEvicted) {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			break
+		}
+		v := &Message{}
+		if err := json.Unmarshal(p, v); err != nil || v.Type != messageType1 {
+			t.Fatalf("unexpected message: %s", p)
+		}
+	}
+	s.clientRemovedWG.Wait()
+	c2.close(s)
+}
+
+func TestHeraldEvictionIdle(t *testing.T) {
+
+	// Create the server with room for a few small messages
+	s := newTestServer(func(h *Herald) {
+		h.MaxQueuedBytes = 30
+		h.EvictionPolicy = EvictIdleLongest
+	})
+	defer s.herald.Close()
+
+	// Create two idle clients followed by a client that is throttled so
+	// that messages remain in its queue
+	var (
+		c1 = newTestClient(t, s)
+		c2 = newTestClient(t, s)
+		c3 = newTestClient(t, s)
+		c4 = newTestClient(t, s)
+	)
+	c3.client.SetThrottle(&Throttle{MessagesPerSecond: 1})
+	for i := 0; i < 3; i++ {
+		if err := s.herald.Send(newTestMessage(t, messageType1), []*Client{c3.client}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Send a large message to the last client and ensure that only the
+	// client with queued messages is evicted, even though the others have
+	// been idle for longer
+	m, err := NewMessage(messageType2, strings.Repeat("x", 20))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.clientRemovedWG.Add(1)
+	if err := s.herald.Send(m, []*Client{c4.client}); err != nil {
+		t.Fatal(err)
+	}
+	c4.receive(t, s, m)
+	s.clientRemovedWG.Wait()
+	if n := s.herald.ClientCount(); n != 3 {
+		t.Fatalf("%d != 3", n)
+	}
+	c1.close(s)
+	c2.close(s)
+	c4.close(s)
+}
@@ -0,0 +1,43 @@
+package herald
+
+// SendExcept sends the message to all clients connected to this instance
+// except for the specified clients. The recipients are determined when the
+// message is delivered, so clients that connect before then also receive it.
+// ErrClosed is returned if the Herald is shutting down.
+func (h *Herald) SendExcept(message *Message, clients []*Client) error {
+	excluded := make(map[*Client]struct{}, len(clients))
+	for _, c := range clients {
+		excluded[c] = struct{}{}
+	}
+	return h.queueSend(&sendParams{
+		messages: []*Message{message},
+		except: func(c *Client) bool {
+			_, ok := excluded[c]
+			return ok
+		},
+	})
+}
+
+// SendExceptGroup sends the message to all clients connected to this
+// instance that are not in the specified group. ErrClosed is returned if the
+// Herald is shutting down.
+func (h *Herald) SendExceptGroup(group string, message *Message) error {
+	return h.queueSend(&sendParams{
+		messages: []*Message{message},
+		except: func(c *Client) bool {
+			return c.group == group
+		},
+	})
+}
+
+// SendExceptIndex sends the message to all clients connected to this
+// instance that are not indexed under the key in the named index (see
+// AddIndex). ErrClosed is returned if the Herald is shutting down.
+func (h *Herald) SendExceptIndex(name, key string, message *Message) error {
+	return h.queueSend(&sendParams{
+		messages: []*Message{message},
+		except: func(c *Client) bool {
+			return h.indexed(name, key, c)
+		},
+	})
+}
@@ -0,0 +1,39 @@
+package herald
+
+import (
+	"testing"
+)
+
+func TestHeraldSendExcept(t *testing.T) {
+
+	// Create the server and one client in the first group and two in the
+	// second group
+	var (
+		s  = newTestServer()
+		c1 = newTestGroupClient(t, s, "a")
+		c2 = newTestGroupClient(t, s, "b")
+		c3 = newTestGroupClient(t, s, "b")
+
+		m1 = newTestMessage(t, messageType1)
+		m2 = newTestMessage(t, messageType2)
+	)
+	defer s.herald.Close()
+
+	// Send a message to everyone except the first client, a message to
+	// everyone outside of the second group, and a broadcast
+	s.herald.SendExcept(m1, []*Client{c1.client})
+	s.herald.SendExceptGroup("b", m2)
+	s.herald.Send(m1, nil)
+
+	// Ensure each client only received the messages intended for it
+	c1.receive(t, s, m2)
+	c1.receive(t, s, m1)
+	for _, c := range []*testClient{c2, c3} {
+		c.receive(t, s, m1)
+		c.receive(t, s, m1)
+	}
+
+	c1.close(s)
+	c2.close(s)
+	c3.close(s)
+}
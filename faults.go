@@ -0,0 +1,75 @@
+package herald
+
+import (
+	"math/rand"
+	"time"
+)
+
+// Faults describes artificial failures injected when writing messages to a
+// client. It is intended for testing how applications and their clients cope
+// with slow networks, lost messages, and dropped connections.
+type Faults struct {
+
+	// Latency is added before each message is written.
+	Latency time.Duration
+
+	// DropRate is the probability, between 0 and 1, that a message is
+	// silently discarded instead of being written. Discarded messages are
+	// reported as written, as they would be if lost on the network.
+	DropRate float64
+
+	// DisconnectAfter disconnects the client after the specified number of
+	// messages have been written or discarded. A value of zero disables
+	// disconnection.
+	DisconnectAfter int
+
+	// Seed initializes the random number generator used for DropRate so
+	// that the sequence of discarded messages is reproducible.
+	Seed int64
+}
+
+// faultState tracks the progress of injected faults for a client.
+type faultState struct {
+	faults *Faults
+	rand   *rand.Rand
+	count  int
+}
+
+// SetFaults specifies the faults to inject when writing messages to the
+// client. Passing nil disables fault injection.
+func (c *Client) SetFaults(f *Faults) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if f == nil {
+		c.faults = nil
+		return
+	}
+	c.faults = &faultState{
+		faults: f,
+		rand:   rand.New(rand.NewSource(f.Seed)),
+	}
+}
+
+// injectFaults applies the client's faults before a message is written. It
+// returns whether the message should be discarded and whether the client
+// should be disconnected afterwards.
+func (c *Client) injectFaults() (drop, disconnect bool) {
+	c.mutex.Lock()
+	s := c.faults
+	if s == nil {
+		c.mutex.Unlock()
+		return false, false
+	}
+	f := s.faults
+	s.count++
+	drop = f.DropRate > 0 && s.rand.Float64() < f.DropRate
+	disconnect = f.DisconnectAfter > 0 && s.count >= f.DisconnectAfter
+	c.mutex.Unlock()
+	if f.Latency > 0 {
+		select {
+		case <-c.herald.clock().After(f.Latency):
+		case <-c.ctx.Done():
+		}
+	}
+	return
+}
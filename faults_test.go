@@ -0,0 +1,38 @@
+package herald
+
+import (
+	"testing"
+)
+
+func TestClientFaults(t *testing.T) {
+
+	// Create the server with faults that drop every message sent to the
+	// first client and disconnect the second after two messages
+	var (
+		s  = newTestServer()
+		c1 = newTestClient(t, s)
+		c2 = newTestClient(t, s)
+
+		m1 = newTestMessage(t, messageType1)
+		m2 = newTestMessage(t, messageType2)
+	)
+	defer s.herald.Close()
+	c1.client.SetFaults(&Faults{DropRate: 1})
+	c2.client.SetFaults(&Faults{DisconnectAfter: 2})
+
+	// Ensure the first client receives nothing and the second receives both
+	// messages before being disconnected
+	s.clientRemovedWG.Add(1)
+	s.herald.Send(m1, nil)
+	s.herald.Send(m2, nil)
+	c2.receive(t, s, m1)
+	c2.receive(t, s, m2)
+	c2.verifyDisconnected(t)
+	s.clientRemovedWG.Wait()
+
+	// Stop injecting faults and ensure the next message arrives
+	c1.client.SetFaults(nil)
+	s.herald.Send(m1, nil)
+	c1.receive(t, s, m1)
+	c1.close(s)
+}
@@ -0,0 +1,91 @@
+//go:build go1.18
+// +build go1.18
+
+package herald
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func FuzzDecodeMessage(f *testing.F) {
+	f.Add(websocket.TextMessage, []byte(`{"type":"test","data":null}`))
+	f.Add(websocket.TextMessage, []byte(`{"type":"test","data":{"a":[1,2,3]},"seq":1}`))
+	f.Add(websocket.TextMessage, []byte(`{"type":1}`))
+	f.Add(websocket.BinaryMessage, []byte{0})
+	f.Fuzz(func(t *testing.T, messageType int, b []byte) {
+		m, err := decodeMessage(messageType, b)
+		if err != nil {
+			return
+		}
+		if _, err := json.Marshal(m); err != nil {
+			t.Fatal(err)
+		}
+	})
+}
+
+func FuzzHMACVerify(f *testing.F) {
+	var (
+		v = &HMACVerifier{
+			Secret:          []byte("secret"),
+			TimestampHeader: "X-Timestamp",
+		}
+		now = time.Now()
+	)
+	f.Add("1", v.Sign([]byte("body"), now), []byte("body"))
+	f.Add("-1", "sha256=", []byte{})
+	f.Fuzz(func(t *testing.T, timestamp, signature string, body []byte) {
+		header := http.Header{}
+		header.Set("X-Timestamp", timestamp)
+		header.Set(defaultSignatureHeader, signature)
+		v.Verify(header, body)
+	})
+}
+
+func FuzzFrames(f *testing.F) {
+
+	// Create a Herald and a server that adds clients to it
+	var (
+		h           = New()
+		removedChan = make(chan *Client)
+		server      = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			h.AddClient(w, r, nil)
+		}))
+		addr = strings.Replace(server.URL, "http", "ws", 1)
+	)
+	h.ClientRemovedHandler = func(c *Client) {
+		removedChan <- c
+	}
+	h.Start()
+	defer h.Close()
+	defer server.Close()
+
+	// Masked text frames containing an empty payload and a message, a binary
+	// frame, and a truncated header
+	f.Add([]byte{0x81, 0x80, 0, 0, 0, 0})
+	f.Add(append([]byte{0x81, 0x9b, 0, 0, 0, 0}, `{"type":"test","data":null}`...))
+	f.Add([]byte{0x82, 0x81, 0, 0, 0, 0, 0})
+	f.Add([]byte{0x81, 0xff})
+
+	// Write the raw bytes after the handshake and ensure that the client is
+	// removed once the connection is closed
+	f.Fuzz(func(t *testing.T, b []byte) {
+		conn, _, err := websocket.DefaultDialer.Dial(addr, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		conn.UnderlyingConn().Write(b)
+		conn.Close()
+		select {
+		case <-removedChan:
+		case <-time.After(receiveTimeout):
+			t.Fatal("client was not removed")
+		}
+	})
+}
@@ -0,0 +1,77 @@
+package herald
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+)
+
+// Attributes set on clients whose IP address was resolved by IPResolver.
+const (
+	CountryAttribute = "country"
+	ASNAttribute     = "asn"
+)
+
+// IPInfo describes the network location of a client's IP address.
+type IPInfo struct {
+
+	// Country is the ISO 3166-1 alpha-2 code of the country the address is
+	// located in or an empty string if it is unknown.
+	Country string
+
+	// ASN is the number of the autonomous system the address belongs to or
+	// zero if it is unknown.
+	ASN uint32
+}
+
+type ipInfoKey struct{}
+
+// resolveIP passes the request to IPResolver and attaches the result to the
+// request's context. Failures are reported and the request is returned
+// unchanged.
+func (h *Herald) resolveIP(r *http.Request) *http.Request {
+	if h.IPResolver == nil {
+		return r
+	}
+	info, err := h.IPResolver(r)
+	if err != nil {
+		h.reportError(&ClientError{
+			Kind: ErrorResolve,
+			Err:  err,
+		})
+		return r
+	}
+	if info == nil {
+		return r
+	}
+	return r.WithContext(context.WithValue(r.Context(), ipInfoKey{}, info))
+}
+
+// RequestIPInfo returns the information resolved by IPResolver for the
+// request or nil if none is available. It can be used by functions
+// registered with UseConnect() to apply regional policies.
+func RequestIPInfo(r *http.Request) *IPInfo {
+	info, _ := r.Context().Value(ipInfoKey{}).(*IPInfo)
+	return info
+}
+
+// setIPAttributes sets CountryAttribute and ASNAttribute on the client.
+func (c *Client) setIPAttributes() {
+	info := c.IPInfo()
+	if info == nil {
+		return
+	}
+	c.SetAttribute(CountryAttribute, info.Country)
+	if info.ASN != 0 {
+		c.SetAttribute(ASNAttribute, strconv.FormatUint(uint64(info.ASN), 10))
+	}
+}
+
+// IPInfo returns the information resolved by IPResolver for the client's IP
+// address or nil if none is available.
+func (c *Client) IPInfo() *IPInfo {
+	if c.request == nil {
+		return nil
+	}
+	return RequestIPInfo(c.request)
+}
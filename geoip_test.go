@@ -0,0 +1,70 @@
+package herald
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+)
+
+func TestHeraldIPResolver(t *testing.T) {
+
+	// Create the server with a resolver that places every address in the
+	// same location and a connect function that rejects other countries
+	var (
+		info = &IPInfo{Country: "NZ", ASN: 64500}
+		s    = newTestServer(func(h *Herald) {
+			h.IPResolver = func(r *http.Request) (*IPInfo, error) {
+				return info, nil
+			}
+			h.UseConnect(func(r *http.Request) error {
+				if i := RequestIPInfo(r); i == nil || i.Country != "NZ" {
+					return errors.New("country not allowed")
+				}
+				return nil
+			})
+		})
+	)
+	defer s.herald.Close()
+
+	// Connect a client and ensure the information was attached
+	c := newTestClient(t, s)
+	if c.client.IPInfo() != info {
+		t.Fatal("information not attached")
+	}
+	if v := c.client.Attribute(CountryAttribute); v != "NZ" {
+		t.Fatalf("%s != NZ", v)
+	}
+	if v := c.client.Attribute(ASNAttribute); v != "64500" {
+		t.Fatalf("%s != 64500", v)
+	}
+	c.close(s)
+}
+
+func TestHeraldIPResolverError(t *testing.T) {
+
+	// Create the server with a resolver that always fails
+	var (
+		errResolve = errors.New("lookup failed")
+		errChan    = make(chan *ClientError, 1)
+		s          = newTestServer(func(h *Herald) {
+			h.IPResolver = func(r *http.Request) (*IPInfo, error) {
+				return nil, errResolve
+			}
+			h.ErrorHandler = func(err *ClientError) {
+				errChan <- err
+			}
+		})
+	)
+	defer s.herald.Close()
+
+	// Ensure the client connects without information and the error is
+	// reported
+	c := newTestClient(t, s)
+	if c.client.IPInfo() != nil {
+		t.Fatal("unexpected information")
+	}
+	if err := <-errChan; err.Kind != ErrorResolve || !errors.Is(err, errResolve) {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	c.close(s)
+}
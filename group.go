@@ -0,0 +1,70 @@
+package herald
+
+import (
+	"net/http"
+)
+
+// addToGroup adds the client to its group, if any. The mutex must be held.
+func (h *Herald) addToGroup(c *Client) {
+	if c.group == "" {
+		return
+	}
+	if h.groups == nil {
+		h.groups = make(map[string][]*Client)
+	}
+	h.groups[c.group] = append(h.groups[c.group], c)
+}
+
+// removeFromGroup removes the client from its group, if any. The mutex must
+// be held.
+func (h *Herald) removeFromGroup(c *Client) {
+	if c.group == "" {
+		return
+	}
+	clients := h.groups[c.group]
+	for i, v := range clients {
+		if v == c {
+			clients = append(clients[:i], clients[i+1:]...)
+			break
+		}
+	}
+	if len(clients) == 0 {
+		delete(h.groups, c.group)
+	} else {
+		h.groups[c.group] = clients
+	}
+}
+
+// AddClientToGroup works like AddClient but places the client in the
+// specified group. Each client belongs to at most one group for the lifetime
+// of its connection, which makes groups suitable for partitioning clients by
+// a shard key. Messages can be sent to the clients in a group with
+// SendToGroup().
+func (h *Herald) AddClientToGroup(w http.ResponseWriter, r *http.Request, data interface{}, group string) (*Client, error) {
+	return h.addClient(w, r, data, nil, group)
+}
+
+// Group returns the name of the group the client belongs to or an empty
+// string if it does not belong to one.
+func (c *Client) Group() string {
+	return c.group
+}
+
+// GroupClients returns the clients in the specified group.
+func (h *Herald) GroupClients(group string) []*Client {
+	h.mutex.RLock()
+	defer h.mutex.RUnlock()
+	return append([]*Client(nil), h.groups[group]...)
+}
+
+// SendToGroup sends the message to all clients in the specified group. The
+// group is resolved when the message is delivered, so clients that join the
+// group before then also receive it. If a backplane is in use, the message is
+// also sent to the clients in the group on every other instance. ErrClosed is
+// returned if the Herald is shutting down.
+func (h *Herald) SendToGroup(group string, message *Message) error {
+	return h.queueSend(&sendParams{
+		messages: []*Message{message},
+		group:    group,
+	})
+}
@@ -0,0 +1,70 @@
+package herald
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/websocket"
+)
+
+func newTestGroupClient(t *testing.T, s *testServer, group string) *testClient {
+	s.clientAddedWG.Add(1)
+	var (
+		c      = &testClient{}
+		server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			client, err := s.herald.AddClientToGroup(w, r, clientData, group)
+			if err != nil {
+				t.Log(err)
+				t.Fail()
+				return
+			}
+			c.client = client
+		}))
+		addr         = strings.Replace(server.URL, "http", "ws", 1)
+		conn, _, err = websocket.DefaultDialer.Dial(addr, nil)
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.conn = conn
+	server.Close()
+	s.clientAddedWG.Wait()
+	return c
+}
+
+func TestHeraldSendToGroup(t *testing.T) {
+
+	// Create the server and a client in each of two groups
+	var (
+		s  = newTestServer()
+		c1 = newTestGroupClient(t, s, "a")
+		c2 = newTestGroupClient(t, s, "b")
+
+		m1 = newTestMessage(t, messageType1)
+		m2 = newTestMessage(t, messageType2)
+	)
+	defer s.herald.Close()
+	if g := c1.client.Group(); g != "a" {
+		t.Fatalf("%s != a", g)
+	}
+	if n := len(s.herald.GroupClients("a")); n != 1 {
+		t.Fatalf("%d != 1", n)
+	}
+
+	// Send a message to the first group followed by a broadcast; the second
+	// client must only receive the broadcast
+	s.herald.SendToGroup("a", m1)
+	s.herald.Send(m2, nil)
+	c1.receive(t, s, m1)
+	c1.receive(t, s, m2)
+	c2.receive(t, s, m2)
+
+	// Close the clients and ensure the groups are removed
+	c1.close(s)
+	c2.close(s)
+	if n := len(s.herald.GroupClients("a")); n != 0 {
+		t.Fatalf("%d != 0", n)
+	}
+}
@@ -0,0 +1,90 @@
+package herald
+
+import (
+	"errors"
+)
+
+type pendingRetry struct {
+	message *Message
+	attempt int
+}
+
+// invokeHandler invokes the handler for the message, enforcing the handler
+// timeout if one was specified.
+func (h *Herald) invokeHandler(fn HandlerFunc, m *Message, c *Client) error {
+	if h.HandlerTimeout == 0 {
+		return h.callHandler(fn, m, c)
+	}
+	errChan := make(chan error, 1)
+	go func() {
+		errChan <- h.callHandler(fn, m, c)
+	}()
+	select {
+	case err := <-errChan:
+		return err
+	case <-h.clock().After(h.HandlerTimeout):
+
+		// The handler is still running; unless poison messages are being
+		// isolated, cancel the client's context so that it has a chance to
+		// abort and disconnect the client
+		if !h.IsolatePoison {
+			c.cancel()
+			c.conn.Close()
+		}
+		return ErrHandlerTimeout
+	}
+}
+
+// handleMessage processes a message from a client. If the handler fails with
+// an error that can be retried, further reads from the client are suspended
+// until the retry is attempted; otherwise the message is dead-lettered.
+// Messages from quarantined clients are discarded.
+func (h *Herald) handleMessage(m *Message, c *Client, attempt int) {
+	if c.Quarantined() {
+		return
+	}
+	switch {
+	case m.Type == ReauthMessageType && h.ReauthHandler != nil:
+		h.reauth(m, c)
+		return
+	case m.Type == SubscribeMessageType, m.Type == UnsubscribeMessageType:
+		h.subscribe(m, c)
+		return
+	case m.Type == VisibilityMessageType && h.BackgroundThrottle != nil:
+		h.visibility(m, c)
+		return
+	case m.Type == TimeSyncMessageType && h.TimeSync:
+		h.timeSync(m, c)
+		return
+	}
+	fn := h.handlerFor(m, c)
+	if fn == nil {
+		return
+	}
+	err := h.invokeHandler(fn, m, c)
+	if err == nil {
+		h.recordLastMessage(m, c)
+		return
+	}
+	var p *permanentError
+	if h.HandlerRetryPolicy != nil &&
+		attempt <= h.HandlerRetryPolicy.MaxRetries &&
+		!errors.Is(err, ErrHandlerTimeout) &&
+		!(h.IsolatePoison && errors.Is(err, ErrHandlerPanic)) &&
+		!errors.As(err, &p) {
+		c.retry = &pendingRetry{
+			message: m,
+			attempt: attempt + 1,
+		}
+		d := h.HandlerRetryPolicy.backoff(attempt - 1)
+		go func() {
+			<-h.clock().After(d)
+			select {
+			case h.retryChan <- c:
+			case <-h.closedChan:
+			}
+		}()
+		return
+	}
+	h.deadLetter(m, c, attempt, err)
+}
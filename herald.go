@@ -1,26 +1,282 @@
 package herald
 
 import (
+	"context"
 	"net/http"
 	"reflect"
 	"sync"
+	"time"
 
 	"github.com/gorilla/websocket"
 )
 
 type sendParams struct {
-	message *Message
-	clients []*Client
+	messages   []*Message
+	clients    []*Client
+	resultChan chan []*SendResult
+	receipt    *Receipt
+	group      string
+	room       string
+	except     func(c *Client) bool
+	remote     bool
+	ephemeral  bool
 }
 
 // Herald maintains a set of WebSocket connections and facilitates the exchange
 // of messages between them.
 type Herald struct {
 
-	// MessageHandler processes messages as they are coming in. If nil,
-	// messages will simply be re-broadcast to all clients.
+	// MessageHandler processes messages as they are coming in. By default,
+	// messages are simply re-broadcast to all clients.
 	MessageHandler func(message *Message, client *Client)
 
+	// MessageHandlerWithError is used instead of MessageHandler if it is not
+	// nil. If it returns an error, the message may be retried according to
+	// HandlerRetryPolicy and is otherwise passed to DeadLetterHandler.
+	MessageHandlerWithError HandlerFunc
+
+	// UnknownTypePolicy determines how messages are processed when handlers
+	// have been registered with Handle() and none of them match the type of
+	// the message. By default, such messages are passed to
+	// MessageHandlerWithError or MessageHandler.
+	UnknownTypePolicy UnknownTypePolicy
+
+	// HandlerRetryPolicy determines how messages that a handler failed to
+	// process are retried. While a retry is pending, no further messages
+	// are read from the client. If nil, failed messages are not retried.
+	HandlerRetryPolicy *RetryPolicy
+
+	// HandlerTimeout specifies the maximum amount of time a handler may
+	// spend processing a single message. If the timeout is exceeded, the
+	// client's context is cancelled and the client is disconnected. A value
+	// of zero disables the timeout.
+	HandlerTimeout time.Duration
+
+	// IsolatePoison indicates that messages whose handler panics or times
+	// out are dead-lettered immediately, without being retried, and that the
+	// client that sent them remains connected so that its subsequent
+	// messages continue to be processed. A handler that times out is left
+	// running in the background and the client's context is not cancelled.
+	IsolatePoison bool
+
+	// HandshakeTimeout specifies how long a new client has to send its first
+	// message before it is disconnected. This prevents anonymous clients from
+	// holding connections open without identifying themselves. A value of
+	// zero disables the timeout.
+	HandshakeTimeout time.Duration
+
+	// HandshakeType specifies the type that the first message sent by each
+	// client must have. Clients that send a message of any other type first
+	// are disconnected. If empty, the first message may have any type.
+	HandshakeType string
+
+	// IPResolver looks up the network location of each new connection,
+	// typically from the address in RemoteAddr or, behind a trusted proxy, a
+	// forwarded header. The result is available to functions registered
+	// with UseConnect() through RequestIPInfo(), is returned by
+	// Client.IPInfo(), and sets the client's CountryAttribute and
+	// ASNAttribute attributes. It is invoked for every connection before it
+	// is admitted, so it should use a local database. If nil, addresses are
+	// not resolved.
+	IPResolver func(r *http.Request) (*IPInfo, error)
+
+	// AbusePolicy scores clients for misbehaviour, such as sending invalid
+	// messages or failing to authenticate, and throttles, quarantines, or
+	// bans them as their score rises. If nil, clients are not scored.
+	AbusePolicy *AbusePolicy
+
+	// KeyExchange derives a key shared with each client from the first
+	// message it sends, such as by combining an ephemeral X25519 public key
+	// in the message with one generated by the server. The key is stored on
+	// the client and can be retrieved with Client.Key() by the layer that
+	// encrypts payloads. If a reply is returned, it is sent to the client so
+	// that it can derive the same key. If an error is returned, the client
+	// is disconnected. If nil, no key is derived.
+	KeyExchange func(client *Client, handshake *Message) (key []byte, reply *Message, err error)
+
+	// ReauthHandler validates the credentials presented by a connected
+	// client in a message of type ReauthMessageType and returns the client's
+	// new data, which replaces the old data as if by Client.SetData(). If an
+	// error is returned, the client is disconnected with CloseUnauthorized.
+	// The handler is invoked by the run loop, so it must not block and
+	// HandlerTimeout does not apply to it. If nil, such messages are
+	// processed like any other message.
+	ReauthHandler func(client *Client, token string) (interface{}, error)
+
+	// CanSubscribe determines whether a client may subscribe to the State
+	// with the specified name. If an error is returned, State.Subscribe()
+	// returns it without subscribing the client. If nil, clients may
+	// subscribe to any state.
+	CanSubscribe func(client *Client, state string) error
+
+	// SessionStore saves the attributes and State subscriptions of each
+	// client when it disconnects so that they can be restored when it
+	// reconnects, even to a different instance. If nil, sessions are not
+	// used.
+	SessionStore SessionStore
+
+	// SessionIdentity returns the identity of the user making the request,
+	// such as the ID of an authenticated user. It is saved with each session
+	// and a session is only resumed by requests with the same identity. If
+	// nil, any client that presents the ID of a session may resume it.
+	SessionIdentity func(r *http.Request) string
+
+	// TimeSync indicates that messages of type TimeSyncMessageType are
+	// answered with the server's time so that clients can estimate the
+	// offset of their clock. If false, such messages are processed like any
+	// other message.
+	TimeSync bool
+
+	// KeepaliveInterval specifies how often clients are pinged. Clients that
+	// neither respond to a ping nor send a message for twice their interval
+	// are disconnected. Clients may request a different interval with the
+	// "keepalive" capability (see Capabilities), within the limits set by
+	// MinKeepaliveInterval and MaxKeepaliveInterval, and the agreed interval
+	// is sent in the X-Herald-Keepalive response header in milliseconds. A
+	// value of zero disables keepalives.
+	KeepaliveInterval time.Duration
+
+	// MinKeepaliveInterval specifies the shortest keepalive interval a
+	// client may request. A value of zero imposes no limit.
+	MinKeepaliveInterval time.Duration
+
+	// MaxKeepaliveInterval specifies the longest keepalive interval a client
+	// may request. A value of zero imposes no limit.
+	MaxKeepaliveInterval time.Duration
+
+	// DeadLetterHandler receives messages that could not be processed, along
+	// with the reason for the failure. This includes messages that failed all
+	// retries, messages whose handler timed out, messages rejected by
+	// UnknownTypePolicy, and messages that could not be converted to the
+	// latest version of their type. This field is optional.
+	DeadLetterHandler func(deadLetter *DeadLetter)
+
+	// Signer signs each message sent to clients so that they can verify it
+	// with VerifyMessage(). If nil, messages are not signed.
+	Signer Signer
+
+	// ClientThrottle specifies the default rate limits applied to messages
+	// written to each new client. The limits for an individual client can be
+	// changed with Client.SetThrottle(). If nil, writes are not limited.
+	ClientThrottle *Throttle
+
+	// BackgroundThrottle specifies the rate limits applied to clients while
+	// they report that they are in the background with a message of type
+	// VisibilityMessageType. Coalesce should be set so that such clients
+	// receive only the latest message of each type, other than State deltas
+	// and other messages with a sequence number, instead of being
+	// disconnected when their queues fill (see Throttle). The client's
+	// previous limits are restored when it returns to the foreground. If nil,
+	// such messages are processed like any other message.
+	BackgroundThrottle *Throttle
+
+	// ClientLinger specifies the default linger duration for each new client,
+	// which determines how long Client.Close() waits for queued messages to
+	// be written. The duration for an individual client can be changed with
+	// Client.SetLinger(). A value of zero disconnects clients immediately.
+	ClientLinger time.Duration
+
+	// ClientFaults specifies artificial failures to inject for each new
+	// client, for use in tests. The faults for an individual client can be
+	// changed with Client.SetFaults(). If nil, no faults are injected.
+	ClientFaults *Faults
+
+	// Discovery provides the list of instances in the cluster when a
+	// backplane is in use. If nil, the backplane itself is used. This field
+	// must be set before calling SetBackplane().
+	Discovery Discovery
+
+	// MembershipInterval specifies how often the list of instances is
+	// retrieved from the backplane when one is in use. A value of zero
+	// disables periodic retrieval. This field must be set before calling
+	// SetBackplane().
+	MembershipInterval time.Duration
+
+	// AcceptRate limits the number of new connections accepted per second,
+	// protecting the server from reconnect storms after a restart. A value of
+	// zero disables the limit.
+	AcceptRate float64
+
+	// AcceptBurst specifies how many connections can be accepted at once
+	// before AcceptRate applies.
+	AcceptBurst int
+
+	// AcceptMaxWait specifies how long a new connection may be held waiting
+	// for AcceptRate to permit it. Connections that would need to wait longer
+	// are rejected with 503 Service Unavailable and a Retry-After header.
+	AcceptMaxWait time.Duration
+
+	// MaxQueuedBytes limits the approximate number of bytes of messages
+	// waiting to be written to all clients combined, so that a slow or
+	// stalled set of clients cannot exhaust memory. When a message cannot be
+	// queued for a client without exceeding the limit, clients are evicted
+	// according to EvictionPolicy. A value of zero disables the limit.
+	MaxQueuedBytes int64
+
+	// EvictionPolicy chooses clients to disconnect when queueing a message
+	// would exceed MaxQueuedBytes so that memory can be freed for it.
+	// EvictIdleLongest, EvictMostBacklogged, and EvictLowestPriority are
+	// provided. If nil, the client the message is being queued for is
+	// disconnected.
+	EvictionPolicy EvictionPolicy
+
+	// BackplanePauseBytes specifies the approximate number of queued bytes
+	// above which messages are no longer accepted from the backplane until
+	// enough of the queued messages have been written. A value of zero
+	// disables pausing.
+	BackplanePauseBytes int64
+
+	// ShutdownTimeout specifies how long Close() waits for queued messages
+	// to be written to clients before disconnecting them.
+	ShutdownTimeout time.Duration
+
+	// DrainNotice is sent to all clients when shutdown begins, after any
+	// messages that were already sent, so that they can reconnect to another
+	// instance. If nil, no notice is sent.
+	DrainNotice *DrainNotice
+
+	// Clock provides the current time for timeouts, deadlines, TTLs, and
+	// rate limits. If nil, the system clock is used. Socket deadlines, such
+	// as HandshakeTimeout, always use the system clock. This field must be
+	// set before calling Start().
+	Clock Clock
+
+	// ProfileLabels indicates that message handlers and writes to clients
+	// are run with the pprof labels "herald_type" and "herald_group", set to
+	// the type of the message and the group of the client. This allows CPU
+	// and allocation profiles to be broken down by message type and group,
+	// for example with "go tool pprof -tagfocus". Only the first hundred
+	// message types are labelled individually; later types are labelled
+	// "other".
+	ProfileLabels bool
+
+	// ProfileSampleRate specifies the fraction of handler invocations and
+	// writes to clients that are timed and attributed to their message type
+	// and client, without profiling the whole process. The results are
+	// available from Profile() and ProfileHandler(). A value of zero
+	// disables sampling.
+	ProfileSampleRate float64
+
+	// ErrorHandler receives errors that occur while exchanging messages with
+	// clients, such as handler panics, write failures, and malformed messages.
+	// It may be invoked from multiple goroutines simultaneously. This field is
+	// optional.
+	ErrorHandler func(err *ClientError)
+
+	// RequireReady indicates that clients may connect before the application
+	// has finished starting up but messages are neither sent to nor received
+	// from them until Ready() is called. Messages sent in the meantime are
+	// held and delivered once the Herald is ready.
+	RequireReady bool
+
+	// UpgradeFailureHandler is invoked for each connection that could not be
+	// established, including connections that were rejected before being
+	// upgraded and clients that failed to complete the handshake (see
+	// HandshakeTimeout). This allows attacks to be distinguished from broken
+	// clients. It may be invoked from multiple goroutines simultaneously.
+	// This field is optional.
+	UpgradeFailureHandler func(r *http.Request, failure UpgradeFailure, err error)
+
 	// ClientAddedHandler processes new clients after they connect. This field
 	// is optional.
 	ClientAddedHandler func(client *Client)
@@ -29,30 +285,126 @@ type Herald struct {
 	// is optional.
 	ClientRemovedHandler func(client *Client)
 
+	// ClientBatchHandler receives the clients that connected and disconnected
+	// since it was last invoked, once every ClientBatchInterval. Clients
+	// that connect and disconnect within the same interval are omitted. It
+	// is not invoked if there were no changes. This field is optional and may
+	// be used instead of or in addition to ClientAddedHandler and
+	// ClientRemovedHandler.
+	ClientBatchHandler func(added, removed []*Client)
+
+	// ClientBatchInterval specifies how often ClientBatchHandler is invoked.
+	// If zero, one second is used.
+	ClientBatchInterval time.Duration
+
 	mutex          sync.RWMutex
 	upgrader       *websocket.Upgrader
+	connectFuncs   []func(r *http.Request) error
+	acceptMutex    sync.Mutex
+	acceptBucket   *tokenBucket
+	handlers       map[string]HandlerFunc
+	transforms     map[string][]TransformFunc
+	types          map[string][]TypeVersion
 	clients        []*Client
+	states         []*State
+	indexes        map[string]*index
+	groups         map[string][]*Client
+	rooms          map[string][]*Client
+	clientRooms    map[*Client][]string
+	retained       []*Message
+	lastMessages   map[string]*LastMessage
+	maintenance    *Message
+	id             string
+	backplane      *backplaneState
 	addClientChan  chan *Client
-	sendParamsChan chan *sendParams
+	sendMutex      sync.Mutex
+	sendQueue      []*sendParams
+	closing        bool
+	shutdownCtx    context.Context
+	sendSignalChan chan struct{}
+	retryChan      chan *Client
+	snapshotChan   chan chan *Snapshot
 	closeChan      chan struct{}
 	closedChan     chan struct{}
+	readyOnce      sync.Once
+	readyChan      chan struct{}
+	batch          clientBatch
+	expired        uint64
+	dropMutex      sync.Mutex
+	failureMutex   sync.Mutex
+	failures       map[UpgradeFailure]uint64
+	memoryMutex    sync.Mutex
+	queuedBytes    int64
+	memoryWaiters  []chan struct{}
+	abuseMutex     sync.Mutex
+	abuseScores    map[string]*AbuseScore
+	abuseMisses    map[string]time.Time
+	abusePruned    time.Time
+	profiler       profiler
+	drops          []*Drop
+}
+
+// queueSend adds the parameters to the send queue and signals the run loop.
+// ErrClosed is returned if the Herald is shutting down.
+func (h *Herald) queueSend(p *sendParams) error {
+	h.sendMutex.Lock()
+	if h.closing {
+		h.sendMutex.Unlock()
+		return ErrClosed
+	}
+	h.sendQueue = append(h.sendQueue, p)
+	h.sendMutex.Unlock()
+	select {
+	case h.sendSignalChan <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// takeSendQueue removes and returns all of the queued send parameters.
+func (h *Herald) takeSendQueue() []*sendParams {
+	h.sendMutex.Lock()
+	defer h.sendMutex.Unlock()
+	q := h.sendQueue
+	h.sendQueue = nil
+	return q
 }
 
 func (h *Herald) run() {
 	defer close(h.closedChan)
+	defer h.flushBatch()
+	batchChan, stopBatch := h.batchTicker()
+	defer stopBatch()
+	var readyChan <-chan struct{}
+	if h.RequireReady {
+		readyChan = h.readyChan
+	}
 	shuttingDown := false
 	for {
 
+		// Messages are neither sent nor received until the Herald is ready
+		sendSignalChan := h.sendSignalChan
+		if readyChan != nil {
+			sendSignalChan = nil
+		}
+
 		// The list of select cases needs to assembled at runtime so that the
 		// read and closed channels from the clients can be included
 
 		var cases []reflect.SelectCase
 		for _, c := range h.clients {
+
+			// If a retry is pending for the client or the Herald is not
+			// ready, hold off on reading further messages
+			readChan := c.readChan
+			if c.retry != nil || readyChan != nil {
+				readChan = nil
+			}
 			cases = append(
 				cases,
 				reflect.SelectCase{
 					Dir:  reflect.SelectRecv,
-					Chan: reflect.ValueOf(c.readChan),
+					Chan: reflect.ValueOf(readChan),
 				},
 				reflect.SelectCase{
 					Dir:  reflect.SelectRecv,
@@ -76,9 +428,14 @@ func (h *Herald) run() {
 				return
 			}
 
-			// Add cases for the addClient and sendParams channel
+			// Add cases for the addClient, sendSignal, retry, snapshot,
+			// batch, and ready channels
 			addClientIdx  = addCase(reflect.ValueOf(h.addClientChan))
-			sendParamsIdx = addCase(reflect.ValueOf(h.sendParamsChan))
+			sendSignalIdx = addCase(reflect.ValueOf(sendSignalChan))
+			retryIdx      = addCase(reflect.ValueOf(h.retryChan))
+			snapshotIdx   = addCase(reflect.ValueOf(h.snapshotChan))
+			batchIdx      = addCase(reflect.ValueOf(batchChan))
+			readyIdx      = addCase(reflect.ValueOf(readyChan))
 			closeIdx      = -1
 		)
 
@@ -105,16 +462,15 @@ func (h *Herald) run() {
 
 					// A value was received; handle it
 					m := recv.Interface().(*Message)
-					h.MessageHandler(m, c)
+					h.handleMessage(m, c, 1)
 				} else {
 
 					// If the read channel is closed, nothing more can be read;
 					// set the channel to nil to prevent short-circuiting the
-					// select{} statement; close the write channel and set it
-					// to nil since writing to the socket is impossible
-					close(c.writeChan)
+					// select{} statement; close the write queue since writing
+					// to the socket is impossible
+					c.queue.close()
 					c.readChan = nil
-					c.writeChan = nil
 				}
 			} else {
 
@@ -125,10 +481,17 @@ func (h *Herald) run() {
 					h.mutex.Lock()
 					defer h.mutex.Unlock()
 					h.clients = append(h.clients[:clientIdx], h.clients[clientIdx+1:]...)
+					h.removeFromIndexes(c)
+					h.removeFromGroup(c)
+					h.removeFromRooms(c)
 				}()
+				h.saveSession(c)
+				h.unsubscribeStates(c)
+				h.profiler.forget(c)
 				if h.ClientRemovedHandler != nil {
 					h.ClientRemovedHandler(c)
 				}
+				h.batchRemoved(c)
 				if shuttingDown && len(h.clients) == 0 {
 					return
 				}
@@ -137,36 +500,60 @@ func (h *Herald) run() {
 		// New client has connected
 		case chosen == addClientIdx:
 			c := recv.Interface().(*Client)
+			h.sendRetained(c)
 			if h.ClientAddedHandler != nil {
 				h.ClientAddedHandler(c)
 			}
+			h.batchAdded(c)
 			func() {
 				h.mutex.Lock()
 				defer h.mutex.Unlock()
 				h.clients = append(h.clients, c)
+				h.addToIndexes(c)
+				h.addToGroup(c)
 			}()
+			if shuttingDown {
+				c.shutdown(h.shutdownCtx)
+			}
 
-		// Message to send
-		case chosen == sendParamsIdx:
-			p := recv.Interface().(*sendParams)
-			if p.clients == nil {
-				p.clients = h.clients
+		// Messages to send
+		case chosen == sendSignalIdx:
+			for _, p := range h.takeSendQueue() {
+				h.deliver(p)
 			}
-			for _, c := range p.clients {
-				if c.writeChan != nil {
-					select {
-					case c.writeChan <- p.message:
-					default:
-						c.conn.Close()
-					}
-				}
+
+		// Retry a message that previously failed
+		case chosen == retryIdx:
+			c := recv.Interface().(*Client)
+			r := c.retry
+			c.retry = nil
+			h.handleMessage(r.message, c, r.attempt)
+
+		// Capture a snapshot of the current state
+		case chosen == snapshotIdx:
+			recv.Interface().(chan *Snapshot) <- h.snapshot()
+
+		// Deliver the membership changes since the last batch
+		case chosen == batchIdx:
+			h.flushBatch()
+
+		// The application is ready; begin dispatching messages
+		case chosen == readyIdx:
+			readyChan = nil
+			for _, p := range h.takeSendQueue() {
+				h.deliver(p)
 			}
 
-		// Start shutting all of the clients down and return when complete
+		// Queue any remaining messages and the drain notice, start shutting
+		// all of the clients down, and return when complete
 		case chosen == closeIdx:
+			for _, p := range h.takeSendQueue() {
+				h.deliver(p)
+			}
+			h.sendDrainNotice()
 			if len(h.clients) > 0 {
 				for _, c := range h.clients {
-					c.conn.Close()
+					c.shutdown(h.shutdownCtx)
 				}
 				shuttingDown = true
 			} else {
@@ -180,11 +567,17 @@ func (h *Herald) run() {
 // started until the Start() method is invoked.
 func New() *Herald {
 	h := &Herald{
-		upgrader:       &websocket.Upgrader{},
-		addClientChan:  make(chan *Client),
-		sendParamsChan: make(chan *sendParams),
-		closeChan:      make(chan struct{}),
-		closedChan:     make(chan struct{}),
+		MembershipInterval: 10 * time.Second,
+		ShutdownTimeout:    5 * time.Second,
+		id:                 newID(),
+		upgrader:           &websocket.Upgrader{Error: writeRejection},
+		addClientChan:      make(chan *Client),
+		sendSignalChan:     make(chan struct{}, 1),
+		retryChan:          make(chan *Client),
+		snapshotChan:       make(chan chan *Snapshot),
+		closeChan:          make(chan struct{}),
+		closedChan:         make(chan struct{}),
+		readyChan:          make(chan struct{}),
 	}
 	h.MessageHandler = func(m *Message, c *Client) {
 		h.Send(m, nil)
@@ -197,43 +590,161 @@ func (h *Herald) Start() {
 	go h.run()
 }
 
-// AddClient adds a new WebSocket client and begins exchanging messages.
+// Ready signals that the application has finished starting up and that
+// messages can be dispatched. It has no effect unless RequireReady is set.
+// Calling Ready more than once has no effect.
+func (h *Herald) Ready() {
+	h.readyOnce.Do(func() {
+		close(h.readyChan)
+	})
+}
+
+// AddClient adds a new WebSocket client and begins exchanging messages. If the
+// Herald is shutting down, the request is rejected and ErrClosed is returned.
+// If maintenance mode is enabled, a MaintenanceError wrapping ErrMaintenance
+// is returned. If AcceptRate is exceeded, ErrAcceptLimited is returned. If the
+// origin of the request is not allowed, ErrBadOrigin is returned. If a
+// function registered with UseConnect() rejects the request, its error is
+// returned.
 func (h *Herald) AddClient(w http.ResponseWriter, r *http.Request, data interface{}) (*Client, error) {
-	c, err := h.upgrader.Upgrade(w, r, nil)
+	return h.AddClientWithHeader(w, r, data, nil)
+}
+
+// AddClientWithHeader works like AddClient but includes the provided headers
+// in the response to the upgrade request. This can be used to set cookies or
+// to identify the server.
+func (h *Herald) AddClientWithHeader(w http.ResponseWriter, r *http.Request, data interface{}, header http.Header) (*Client, error) {
+	return h.addClient(w, r, data, header, "")
+}
+
+// addClient upgrades the connection and adds the client to the specified
+// group, if any.
+func (h *Herald) addClient(w http.ResponseWriter, r *http.Request, data interface{}, header http.Header, group string) (*Client, error) {
+	r = h.resolveIP(r)
+	if failure, err := h.admit(w, r); err != nil {
+		h.upgradeFailed(r, failure, err)
+		return nil, err
+	}
+	var (
+		capabilities = parseCapabilities(r)
+		keepalive    = h.negotiateKeepalive(capabilities.Keepalive)
+		sess         *session
+		saved        *sessionData
+	)
+	if h.SessionStore != nil {
+		sess, saved = h.loadSession(r)
+	}
+	header = withSessionHeader(h.affinityHeader(header), sess)
+	c, err := h.upgrader.Upgrade(w, r, withKeepaliveHeader(header, keepalive))
 	if err != nil {
+		h.upgradeFailed(r, UpgradeBadRequest, err)
 		return nil, err
 	}
+	ctx, cancel := context.WithCancel(context.Background())
 	client := &Client{
-		Data:            data,
-		conn:            c,
-		readChan:        make(chan *Message),
-		writeChan:       make(chan *Message, 10),
-		writeClosedChan: make(chan struct{}),
-		closedChan:      make(chan struct{}),
+		Data:             data,
+		herald:           h,
+		group:            group,
+		capabilities:     capabilities,
+		previousInstance: r.URL.Query().Get(instanceParam),
+		session:          sess,
+		request:          r,
+		keepalive:        keepalive,
+		ctx:              ctx,
+		cancel:           cancel,
+		conn:             c,
+		readChan:         make(chan *Message),
+		queue:            newQueue(h.addQueuedBytes),
+		writeClosedChan:  make(chan struct{}),
+		closedChan:       make(chan struct{}),
 	}
+	if saved != nil {
+		client.restoreSession(saved)
+	}
+	client.touch()
+	client.SetThrottle(h.ClientThrottle)
+	client.SetLinger(h.ClientLinger)
+	client.SetFaults(h.ClientFaults)
+	client.setIPAttributes()
+	h.initAbuse(client, r)
 	go client.readLoop()
 	go client.writeLoop()
-	h.addClientChan <- client
+	if keepalive != 0 {
+		go client.keepaliveLoop()
+	}
+	select {
+	case h.addClientChan <- client:
+	case <-h.closedChan:
+		c.Close()
+		return nil, ErrClosed
+	}
+	if saved != nil {
+		h.resubscribe(client, saved)
+	}
 	return client, nil
 }
 
-// Send sends the specified message to the client specified in the message or
-// all clients if nil. The send operation takes place in a separate goroutine
-// to enable the call to be made from handlers without triggering a deadlock.
-func (h *Herald) Send(message *Message, clients []*Client) {
-	go func() {
-		h.sendParamsChan <- &sendParams{
-			message: message,
-			clients: clients,
-		}
-	}()
+// Send sends the specified message to the specified clients or all clients if
+// nil. The message is queued for delivery without blocking, enabling the call
+// to be made from handlers without triggering a deadlock. Messages are
+// delivered in the order they were sent. ErrClosed is returned if the Herald
+// is shutting down.
+func (h *Herald) Send(message *Message, clients []*Client) error {
+	return h.queueSend(&sendParams{
+		messages: []*Message{message},
+		clients:  clients,
+	})
+}
+
+// SendAll sends a group of messages to the specified clients or all clients
+// if nil. The messages are queued contiguously for each client, ensuring that
+// they are not interleaved with any other messages. The group takes up a
+// single place in each client's queue, regardless of how many messages it
+// contains, although MaxQueuedBytes still applies to all of them. If a
+// client's queue does not have room for the group, none of the messages are
+// queued and the client is disconnected. ErrClosed is returned if the Herald
+// is shutting down.
+func (h *Herald) SendAll(messages []*Message, clients []*Client) error {
+	return h.queueSend(&sendParams{
+		messages: messages,
+		clients:  clients,
+	})
 }
 
-// Clients returns a slice of all currently connected clients.
+// Clients returns a copy of the list of currently connected clients.
 func (h *Herald) Clients() []*Client {
 	h.mutex.RLock()
 	defer h.mutex.RUnlock()
-	return h.clients
+	return append([]*Client(nil), h.clients...)
+}
+
+// ClientCount returns the number of currently connected clients without
+// copying the client list.
+func (h *Herald) ClientCount() int {
+	h.mutex.RLock()
+	defer h.mutex.RUnlock()
+	return len(h.clients)
+}
+
+// countExpired records that a message was discarded because its deadline
+// passed.
+func (h *Herald) countExpired() {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	h.expired++
+}
+
+// ExpiredCount returns the number of times a message was discarded for a
+// client because its deadline passed before it could be written.
+func (h *Herald) ExpiredCount() uint64 {
+	h.mutex.RLock()
+	defer h.mutex.RUnlock()
+	return h.expired
+}
+
+// IsEmpty returns true if no clients are connected.
+func (h *Herald) IsEmpty() bool {
+	return h.ClientCount() == 0
 }
 
 // SetCheckOrigin provides a function that will be invoked for every new
@@ -242,8 +753,17 @@ func (h *Herald) SetCheckOrigin(fn func(*http.Request) bool) {
 	h.upgrader.CheckOrigin = fn
 }
 
-// Close disconnects all clients and stops exchanging messages.
+// Close disconnects all clients and stops exchanging messages. Queued
+// messages are written to clients for up to ShutdownTimeout. See Shutdown()
+// for details.
 func (h *Herald) Close() {
-	close(h.closeChan)
-	<-h.closedChan
+	ctx, cancel := context.WithTimeout(context.Background(), h.ShutdownTimeout)
+	defer cancel()
+	h.Shutdown(ctx)
+}
+
+// Done returns a channel that is closed once the Herald has shut down and all
+// clients have been disconnected.
+func (h *Herald) Done() <-chan struct{} {
+	return h.closedChan
 }
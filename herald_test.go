@@ -1,9 +1,9 @@
 package herald
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
-	"io"
 	"net/http"
 	"net/http/httptest"
 	"reflect"
@@ -39,7 +39,7 @@ type testServer struct {
 	clientRemovedWG *sync.WaitGroup
 }
 
-func newTestServer() *testServer {
+func newTestServer(setup ...func(h *Herald)) *testServer {
 	s := &testServer{
 		herald:          New(),
 		receivedWG:      &sync.WaitGroup{},
@@ -55,6 +55,9 @@ func newTestServer() *testServer {
 	s.herald.ClientRemovedHandler = func(c *Client) {
 		s.clientRemovedWG.Done()
 	}
+	for _, fn := range setup {
+		fn(s.herald)
+	}
 	s.herald.Start()
 	return s
 }
@@ -101,7 +104,7 @@ func (c *testClient) send(t *testing.T, s *testServer, m *Message) {
 	s.receivedWG.Wait()
 }
 
-func (c *testClient) receive(t *testing.T, s *testServer, m *Message) {
+func (c *testClient) receive(t *testing.T, s *testServer, m *Message) *Message {
 	msgChan := make(chan []byte)
 	go func() {
 		defer close(msgChan)
@@ -116,7 +119,7 @@ func (c *testClient) receive(t *testing.T, s *testServer, m *Message) {
 	select {
 	case p, ok := <-msgChan:
 		if !ok {
-			return
+			return nil
 		}
 		receivedMessage := &Message{}
 		if err := json.Unmarshal(p, receivedMessage); err != nil {
@@ -125,9 +128,11 @@ func (c *testClient) receive(t *testing.T, s *testServer, m *Message) {
 		if receivedMessage.Type != m.Type {
 			t.Fatalf("%s != %s", receivedMessage.Type, m.Type)
 		}
+		return receivedMessage
 	case <-time.After(receiveTimeout):
 		t.Fatal("timeout reached")
 	}
+	return nil
 }
 
 func (c *testClient) close(s *testServer) {
@@ -137,8 +142,9 @@ func (c *testClient) close(s *testServer) {
 }
 
 func (c *testClient) verifyDisconnected(t *testing.T) {
-	b := make([]byte, 32)
-	if _, err := c.conn.UnderlyingConn().Read(b); !errors.Is(err, io.EOF) {
+	c.conn.SetReadDeadline(time.Now().Add(receiveTimeout))
+	var closeErr *websocket.CloseError
+	if _, _, err := c.conn.ReadMessage(); !errors.As(err, &closeErr) {
 		t.Fatal("client was not disconnected")
 	}
 }
@@ -154,7 +160,55 @@ func TestHeraldConnect(t *testing.T) {
 	if !reflect.DeepEqual(s.herald.Clients(), []*Client{c.client}) {
 		t.Fatal("client list does not match")
 	}
+	if !reflect.DeepEqual(s.herald.Snapshot().Clients, []*Client{c.client}) {
+		t.Fatal("snapshot client list does not match")
+	}
+	if n := s.herald.ClientCount(); n != 1 {
+		t.Fatalf("%d != 1", n)
+	}
 	c.close(s)
+	if !s.herald.IsEmpty() {
+		t.Fatal("herald is not empty")
+	}
+}
+
+func TestHeraldAddClientWithHeader(t *testing.T) {
+
+	// Create the server
+	s := newTestServer()
+	defer s.herald.Close()
+
+	// Create a client that receives a cookie and a custom header
+	s.clientAddedWG.Add(1)
+	var (
+		server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			header := http.Header{}
+			header.Set("Set-Cookie", "session=test")
+			header.Set("X-Server", "herald")
+			if _, err := s.herald.AddClientWithHeader(w, r, clientData, header); err != nil {
+				t.Log(err)
+				t.Fail()
+			}
+		}))
+		addr            = strings.Replace(server.URL, "http", "ws", 1)
+		conn, resp, err = websocket.DefaultDialer.Dial(addr, nil)
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server.Close()
+	s.clientAddedWG.Wait()
+
+	// Ensure the headers were included in the response
+	if v := resp.Header.Get("X-Server"); v != "herald" {
+		t.Fatalf("%s != herald", v)
+	}
+	if cookies := resp.Cookies(); len(cookies) != 1 || cookies[0].Value != "test" {
+		t.Fatal("cookie was not set")
+	}
+
+	// Close the client
+	(&testClient{conn: conn}).close(s)
 }
 
 func TestHeraldReceive(t *testing.T) {
@@ -215,11 +269,49 @@ func TestHeraldClose(t *testing.T) {
 	// Disconnect the server
 	s.clientRemovedWG.Add(1)
 	s.herald.Close()
+	select {
+	case <-s.herald.Done():
+	default:
+		t.Fatal("done channel was not closed")
+	}
 
 	// Ensure the client was disconnected
 	c.verifyDisconnected(t)
 }
 
+func TestHeraldShutdown(t *testing.T) {
+
+	// Create the server and a client
+	var (
+		s = newTestServer()
+		c = newTestClient(t, s)
+
+		m1 = newTestMessage(t, messageType1)
+		m2 = newTestMessage(t, messageType2)
+	)
+
+	// Queue two messages and shut down immediately
+	s.herald.Send(m1, nil)
+	s.herald.Send(m2, nil)
+	s.clientRemovedWG.Add(1)
+	if err := s.herald.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	// Further messages must be rejected
+	if err := s.herald.Send(m1, nil); !errors.Is(err, ErrClosed) {
+		t.Fatalf("%v != %v", err, ErrClosed)
+	}
+
+	// Ensure both messages were flushed before the close frame
+	c.receive(t, s, m1)
+	c.receive(t, s, m2)
+	c.conn.SetReadDeadline(time.Now().Add(receiveTimeout))
+	if _, _, err := c.conn.ReadMessage(); !websocket.IsCloseError(err, websocket.CloseGoingAway) {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
 func TestClientClose(t *testing.T) {
 
 	// Create the server and a client
@@ -231,8 +323,541 @@ func TestClientClose(t *testing.T) {
 	// Close the client from the server's side and wait
 	s.clientRemovedWG.Add(1)
 	c.client.Close()
+	select {
+	case <-c.client.Done():
+	case <-time.After(receiveTimeout):
+		t.Fatal("timeout reached")
+	}
 	c.client.Wait()
 
 	// Ensure the client was disconnected
 	c.verifyDisconnected(t)
 }
+
+func TestHeraldReady(t *testing.T) {
+
+	// Create the server, requiring it to be marked as ready, and a client
+	var (
+		s = newTestServer(func(h *Herald) {
+			h.RequireReady = true
+		})
+		c = newTestClient(t, s)
+		m = newTestMessage(t, messageType1)
+	)
+	defer s.herald.Close()
+
+	// Ensure the message is held until the Herald is ready
+	resultChan := s.herald.SendWithResults(m, nil)
+	select {
+	case <-resultChan:
+		t.Fatal("message was delivered before ready")
+	case <-time.After(50 * time.Millisecond):
+	}
+	s.herald.Ready()
+	<-resultChan
+	c.receive(t, s, m)
+	c.close(s)
+}
+
+func TestHeraldHandshake(t *testing.T) {
+
+	// Create the server with a short handshake timeout
+	s := newTestServer(func(h *Herald) {
+		h.HandshakeTimeout = 50 * time.Millisecond
+		h.HandshakeType = messageType1
+	})
+	defer s.herald.Close()
+
+	// Ensure that a client sending the handshake message remains connected
+	// after the timeout elapses
+	c1 := newTestClient(t, s)
+	c1.send(t, s, newTestMessage(t, messageType1))
+	time.Sleep(100 * time.Millisecond)
+	c1.send(t, s, newTestMessage(t, messageType2))
+	c1.close(s)
+
+	// Ensure that a client sending nothing is disconnected
+	s.clientRemovedWG.Add(1)
+	c2 := newTestClient(t, s)
+	c2.verifyDisconnected(t)
+	s.clientRemovedWG.Wait()
+
+	// Ensure that a client sending the wrong type first is disconnected
+	s.clientRemovedWG.Add(1)
+	c3 := newTestClient(t, s)
+	b, err := json.Marshal(newTestMessage(t, messageType2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c3.conn.WriteMessage(websocket.TextMessage, b); err != nil {
+		t.Fatal(err)
+	}
+	c3.verifyDisconnected(t)
+	s.clientRemovedWG.Wait()
+
+	// Ensure that both failures were counted
+	if v := s.herald.UpgradeFailures(); !reflect.DeepEqual(v, map[UpgradeFailure]uint64{
+		UpgradeHandshakeTimeout: 1,
+		UpgradeHandshakeInvalid: 1,
+	}) {
+		t.Fatalf("unexpected failures: %v", v)
+	}
+}
+
+func TestClientLinger(t *testing.T) {
+
+	// Create the server and a client that lingers when closed
+	var (
+		s = newTestServer(func(h *Herald) {
+			h.ClientLinger = receiveTimeout
+		})
+		c = newTestClient(t, s)
+		m = newTestMessage(t, messageType1)
+	)
+	defer s.herald.Close()
+
+	// Queue a message and close the client immediately
+	<-s.herald.SendWithResults(m, []*Client{c.client})
+	s.clientRemovedWG.Add(1)
+	c.client.Close()
+
+	// Ensure the message was written before the close frame
+	c.receive(t, s, m)
+	c.conn.SetReadDeadline(time.Now().Add(receiveTimeout))
+	if _, _, err := c.conn.ReadMessage(); !websocket.IsCloseError(err, websocket.CloseGoingAway) {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	c.client.Wait()
+}
+
+func TestClientFlush(t *testing.T) {
+
+	// Create the server and a client
+	var (
+		s = newTestServer()
+		c = newTestClient(t, s)
+		m = newTestMessage(t, messageType1)
+	)
+	defer s.herald.Close()
+
+	// Queue a message, flush it, and ensure it was received
+	<-s.herald.SendWithResults(m, []*Client{c.client})
+	if err := c.client.Flush(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	c.receive(t, s, m)
+
+	// Ensure that flushing a closed client fails
+	s.clientRemovedWG.Add(1)
+	c.client.Close()
+	c.client.Wait()
+	if err := c.client.Flush(context.Background()); !errors.Is(err, ErrClientClosed) {
+		t.Fatalf("%v != %v", err, ErrClientClosed)
+	}
+}
+
+func TestHeraldHandlerTimeout(t *testing.T) {
+
+	// Create the server with a handler that blocks until the client's
+	// context is cancelled
+	s := newTestServer()
+	defer s.herald.Close()
+	s.herald.HandlerTimeout = 10 * time.Millisecond
+	s.herald.MessageHandler = func(m *Message, c *Client) {
+		<-c.Context().Done()
+		s.receivedWG.Done()
+	}
+
+	// Record the message passed to the dead letter handler
+	deadLetterChan := make(chan *DeadLetter, 1)
+	s.herald.DeadLetterHandler = func(d *DeadLetter) {
+		deadLetterChan <- d
+	}
+
+	// Send a message and ensure the client is disconnected
+	c := newTestClient(t, s)
+	s.clientRemovedWG.Add(1)
+	c.send(t, s, newTestMessage(t, messageType1))
+	c.verifyDisconnected(t)
+
+	// Ensure the message was dead-lettered
+	select {
+	case d := <-deadLetterChan:
+		if d.Message.Type != messageType1 {
+			t.Fatalf("%s != %s", d.Message.Type, messageType1)
+		}
+		if !errors.Is(d.Err, ErrHandlerTimeout) {
+			t.Fatalf("unexpected error: %v", d.Err)
+		}
+	case <-time.After(receiveTimeout):
+		t.Fatal("timeout reached")
+	}
+}
+
+func TestHeraldIsolatePoison(t *testing.T) {
+
+	// Create the server with handlers that panic, block, and succeed
+	var (
+		s = newTestServer(func(h *Herald) {
+			h.IsolatePoison = true
+			h.HandlerTimeout = 10 * time.Millisecond
+			h.HandlerRetryPolicy = &RetryPolicy{
+				MaxRetries:     2,
+				InitialBackoff: time.Millisecond,
+			}
+		})
+		blockChan      = make(chan struct{})
+		deadLetterChan = make(chan *DeadLetter, 2)
+	)
+	defer s.herald.Close()
+	defer close(blockChan)
+	s.herald.Handle(messageType1, func(m *Message, c *Client) error {
+		panic("test")
+	})
+	s.herald.Handle(messageType2, func(m *Message, c *Client) error {
+		<-blockChan
+		return nil
+	})
+	s.herald.Handle("test3", func(m *Message, c *Client) error {
+		s.receivedWG.Done()
+		return nil
+	})
+	s.herald.DeadLetterHandler = func(d *DeadLetter) {
+		deadLetterChan <- d
+	}
+
+	// Send both poison messages, ensure that they are dead-lettered after a
+	// single attempt, and that the following message is processed
+	c := newTestClient(t, s)
+	for _, messageType := range []string{messageType1, messageType2} {
+		b, err := json.Marshal(newTestMessage(t, messageType))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := c.conn.WriteMessage(websocket.TextMessage, b); err != nil {
+			t.Fatal(err)
+		}
+	}
+	c.send(t, s, newTestMessage(t, "test3"))
+	for _, messageType := range []string{messageType1, messageType2} {
+		d := <-deadLetterChan
+		if d.Message.Type != messageType || d.Attempts != 1 {
+			t.Fatalf("unexpected dead letter: %+v", d)
+		}
+	}
+	if err := c.client.Context().Err(); err != nil {
+		t.Fatal(err)
+	}
+	c.close(s)
+}
+
+func TestHeraldHandlerRetry(t *testing.T) {
+
+	// Create the server with a handler that fails twice before succeeding
+	var (
+		s        = newTestServer()
+		attempts = 0
+	)
+	defer s.herald.Close()
+	s.herald.HandlerRetryPolicy = &RetryPolicy{
+		MaxRetries:     2,
+		InitialBackoff: time.Millisecond,
+	}
+	s.herald.MessageHandlerWithError = func(m *Message, c *Client) error {
+		attempts++
+		if attempts < 3 {
+			return errors.New("transient")
+		}
+		s.receivedWG.Done()
+		return nil
+	}
+	s.herald.DeadLetterHandler = func(d *DeadLetter) {
+		t.Error("message was dead-lettered")
+	}
+
+	// Send a message and close the client
+	c := newTestClient(t, s)
+	c.send(t, s, newTestMessage(t, messageType1))
+	c.close(s)
+}
+
+func TestHeraldHandlerRetryExhausted(t *testing.T) {
+
+	// Create the server with a handler that always fails
+	s := newTestServer()
+	defer s.herald.Close()
+	s.herald.HandlerRetryPolicy = &RetryPolicy{
+		MaxRetries:     2,
+		InitialBackoff: time.Millisecond,
+	}
+	s.herald.MessageHandlerWithError = func(m *Message, c *Client) error {
+		return errors.New("transient")
+	}
+
+	// Ensure the message is dead-lettered after all of the retries
+	var deadLetter *DeadLetter
+	s.herald.DeadLetterHandler = func(d *DeadLetter) {
+		deadLetter = d
+		s.receivedWG.Done()
+	}
+
+	// Send a message and close the client
+	c := newTestClient(t, s)
+	c.send(t, s, newTestMessage(t, messageType1))
+	if deadLetter.Attempts != 3 {
+		t.Fatalf("%d != 3", deadLetter.Attempts)
+	}
+	c.close(s)
+}
+
+func TestHeraldHandlerPermanentError(t *testing.T) {
+
+	// Create the server with a handler that fails permanently
+	s := newTestServer()
+	defer s.herald.Close()
+	s.herald.HandlerRetryPolicy = &RetryPolicy{
+		MaxRetries:     2,
+		InitialBackoff: time.Millisecond,
+	}
+	s.herald.MessageHandlerWithError = func(m *Message, c *Client) error {
+		return Permanent(errors.New("permanent"))
+	}
+
+	// Ensure the message is dead-lettered without being retried
+	var deadLetter *DeadLetter
+	s.herald.DeadLetterHandler = func(d *DeadLetter) {
+		deadLetter = d
+		s.receivedWG.Done()
+	}
+
+	// Send a message and close the client
+	c := newTestClient(t, s)
+	c.send(t, s, newTestMessage(t, messageType1))
+	if deadLetter.Attempts != 1 {
+		t.Fatalf("%d != 1", deadLetter.Attempts)
+	}
+	c.close(s)
+}
+
+func TestHeraldErrorHandler(t *testing.T) {
+
+	// Create the server with a handler that panics
+	s := newTestServer()
+	defer s.herald.Close()
+	s.herald.MessageHandler = func(m *Message, c *Client) {
+		panic("test")
+	}
+	s.herald.DeadLetterHandler = func(d *DeadLetter) {
+		s.receivedWG.Done()
+	}
+
+	// Record the errors passed to the error handler
+	errChan := make(chan *ClientError, 2)
+	s.herald.ErrorHandler = func(err *ClientError) {
+		errChan <- err
+	}
+
+	// Send a message that causes a panic and a message that cannot be
+	// decoded
+	c := newTestClient(t, s)
+	c.send(t, s, newTestMessage(t, messageType1))
+	if err := c.conn.WriteMessage(websocket.BinaryMessage, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// Ensure both errors were reported
+	for _, kind := range []ErrorKind{ErrorPanic, ErrorProtocol} {
+		select {
+		case err := <-errChan:
+			if err.Kind != kind {
+				t.Fatalf("%s != %s", err.Kind, kind)
+			}
+			if err.Client != c.client {
+				t.Fatal("client does not match")
+			}
+		case <-time.After(receiveTimeout):
+			t.Fatal("timeout reached")
+		}
+	}
+	c.close(s)
+}
+
+func TestHeraldSendWithResults(t *testing.T) {
+
+	// Create the server and two clients, one of which disconnects
+	var (
+		s  = newTestServer()
+		c1 = newTestClient(t, s)
+		c2 = newTestClient(t, s)
+		m  = newTestMessage(t, messageType1)
+	)
+	defer s.herald.Close()
+	c2.close(s)
+
+	// Send a message to both clients and verify the results
+	select {
+	case results := <-s.herald.SendWithResults(m, []*Client{c1.client, c2.client}):
+		if len(results) != 2 {
+			t.Fatalf("%d != 2", len(results))
+		}
+		for i, status := range []DeliveryStatus{StatusQueued, StatusGone} {
+			if results[i].Status != status {
+				t.Fatalf("%s != %s", results[i].Status, status)
+			}
+		}
+	case <-time.After(receiveTimeout):
+		t.Fatal("timeout reached")
+	}
+	c1.receive(t, s, m)
+	c1.close(s)
+}
+
+func TestHeraldSendWithReceipt(t *testing.T) {
+
+	// Create the server and two clients, one of which disconnects
+	var (
+		s  = newTestServer()
+		c1 = newTestClient(t, s)
+		c2 = newTestClient(t, s)
+		m  = newTestMessage(t, messageType1)
+	)
+	defer s.herald.Close()
+	c2.close(s)
+
+	// Ensure writing to the connected client succeeds
+	ctx, cancel := context.WithTimeout(context.Background(), receiveTimeout)
+	defer cancel()
+	if err := s.herald.SendWithReceipt(m, []*Client{c1.client}).Wait(ctx); err != nil {
+		t.Fatal(err)
+	}
+	c1.receive(t, s, m)
+
+	// Ensure writing to the disconnected client fails
+	r := s.herald.SendWithReceipt(m, []*Client{c2.client})
+	if err := r.Wait(ctx); !errors.Is(err, ErrNotDelivered) {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(r.Failed(), []*Client{c2.client}) {
+		t.Fatal("failed client list does not match")
+	}
+	c1.close(s)
+}
+
+func TestHeraldDeadline(t *testing.T) {
+
+	// Create the server, a client, and a message that is already stale
+	var (
+		s  = newTestServer()
+		c  = newTestClient(t, s)
+		m1 = newTestMessage(t, messageType1)
+		m2 = newTestMessage(t, messageType2)
+	)
+	defer s.herald.Close()
+	m1.Deadline = time.Now().Add(-time.Second)
+
+	// Ensure the stale message is discarded and counted
+	ctx, cancel := context.WithTimeout(context.Background(), receiveTimeout)
+	defer cancel()
+	if err := s.herald.SendWithReceipt(m1, nil).Wait(ctx); !errors.Is(err, ErrNotDelivered) {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if n := s.herald.ExpiredCount(); n != 1 {
+		t.Fatalf("%d != 1", n)
+	}
+
+	// Ensure the next message is received instead
+	s.herald.Send(m2, nil)
+	c.receive(t, s, m2)
+	c.close(s)
+}
+
+func TestHeraldSendAll(t *testing.T) {
+
+	// Create the server and a client
+	var (
+		s  = newTestServer()
+		c  = newTestClient(t, s)
+		m1 = newTestMessage(t, messageType1)
+		m2 = newTestMessage(t, messageType2)
+	)
+	defer s.herald.Close()
+
+	// Send both messages as a group and ensure they arrive in order
+	s.herald.SendAll([]*Message{m1, m2}, nil)
+	c.receive(t, s, m1)
+	c.receive(t, s, m2)
+
+	// Send a group larger than the queue and ensure that the client receives
+	// all of it instead of being disconnected
+	messages := make([]*Message, queueSize+1)
+	for i := range messages {
+		messages[i] = newTestMessage(t, messageType1)
+	}
+	s.herald.SendAll(messages, nil)
+	for _, m := range messages {
+		c.receive(t, s, m)
+	}
+	c.close(s)
+}
+
+func TestHeraldCoalesce(t *testing.T) {
+
+	// Create the server and a client that is throttled so that messages back
+	// up in its queue
+	var (
+		s  = newTestServer()
+		c  = newTestClient(t, s)
+		m1 = newTestMessage(t, messageType1)
+		m2 = newTestMessage(t, messageType2)
+		m3 = newTestMessage(t, messageType2)
+	)
+	defer s.herald.Close()
+	c.client.SetThrottle(&Throttle{MessagesPerSecond: 10})
+
+	// The first message is written immediately and the second must wait
+	// for the throttle; the third and fourth messages share a key, so the
+	// third should be discarded while they are queued
+	m2.Key = "key"
+	m3.Key = "key"
+	m3.Seq = 1
+	s.herald.Send(m1, nil)
+	s.herald.Send(m1, nil)
+	s.herald.Send(m2, nil)
+	s.herald.Send(m3, nil)
+	c.receive(t, s, m1)
+	c.receive(t, s, m1)
+	if m := c.receive(t, s, m3); m.Seq != m3.Seq {
+		t.Fatal("message was not coalesced")
+	}
+	c.close(s)
+}
+
+func TestHeraldRetain(t *testing.T) {
+
+	// Create the server and broadcast a retained message
+	var (
+		s = newTestServer()
+		m = newTestMessage(t, messageType1)
+	)
+	defer s.herald.Close()
+	m.Retain = true
+	ctx, cancel := context.WithTimeout(context.Background(), receiveTimeout)
+	defer cancel()
+	if err := s.herald.SendWithReceipt(m, nil).Wait(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if s.herald.Retained(messageType1) != m {
+		t.Fatal("message was not retained")
+	}
+
+	// Ensure a new client receives the message
+	c := newTestClient(t, s)
+	c.receive(t, s, m)
+	c.close(s)
+
+	// Clear the message
+	s.herald.ClearRetained(messageType1)
+	if s.herald.Retained(messageType1) != nil {
+		t.Fatal("message was not cleared")
+	}
+}
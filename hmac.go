@@ -0,0 +1,140 @@
+package herald
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultSignatureHeader = "X-Herald-Signature"
+	defaultTolerance       = 5 * time.Minute
+	signaturePrefix        = "sha256="
+)
+
+var (
+	// ErrInvalidSignature indicates that a request signature is missing or
+	// does not match the request.
+	ErrInvalidSignature = errors.New("invalid signature")
+
+	// ErrInvalidTimestamp indicates that a request timestamp is missing or
+	// outside of the permitted tolerance.
+	ErrInvalidTimestamp = errors.New("invalid timestamp")
+
+	// ErrReplayed indicates that a request with the same signature was
+	// already accepted.
+	ErrReplayed = errors.New("request replayed")
+)
+
+// HMACVerifier authenticates requests signed with HMAC-SHA256 using a shared
+// secret, in the style used by webhook providers. The signature header
+// contains the hex-encoded signature, optionally prefixed with "sha256=". If
+// TimestampHeader is set, the header must contain a Unix timestamp and the
+// signature is computed over the timestamp, a period, and the body;
+// otherwise it is computed over the body alone.
+//
+// Replays are only detected while a signature is remembered, which is twice
+// the tolerance. Without a timestamp, nothing stops a captured request from
+// being replayed after that, so TimestampHeader should be set whenever the
+// sender supports it.
+type HMACVerifier struct {
+
+	// Secret is the shared secret used to compute signatures.
+	Secret []byte
+
+	// SignatureHeader is the name of the header containing the signature. If
+	// empty, "X-Herald-Signature" is used.
+	SignatureHeader string
+
+	// TimestampHeader is the name of the header containing the time the
+	// request was signed. If empty, requests are not timestamped and replays
+	// are only detected within twice the tolerance.
+	TimestampHeader string
+
+	// Tolerance specifies how far a timestamp may differ from the current
+	// time. Signatures are remembered for twice this duration to detect
+	// replays. If zero, five minutes is used.
+	Tolerance time.Duration
+
+	// Clock provides the current time. If nil, the system clock is used.
+	Clock Clock
+
+	mutex sync.Mutex
+	seen  map[string]time.Time
+}
+
+func (v *HMACVerifier) tolerance() time.Duration {
+	if v.Tolerance == 0 {
+		return defaultTolerance
+	}
+	return v.Tolerance
+}
+
+// Sign computes the signature for the body and timestamp. The timestamp is
+// ignored if TimestampHeader is empty.
+func (v *HMACVerifier) Sign(body []byte, timestamp time.Time) string {
+	mac := hmac.New(sha256.New, v.Secret)
+	if v.TimestampHeader != "" {
+		mac.Write([]byte(strconv.FormatInt(timestamp.Unix(), 10) + "."))
+	}
+	mac.Write(body)
+	return signaturePrefix + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verify checks the signature and timestamp in the headers against the body
+// and ensures that the request has not been seen before.
+func (v *HMACVerifier) Verify(header http.Header, body []byte) error {
+	var (
+		now       = clockOrSystem(v.Clock).Now()
+		timestamp time.Time
+	)
+	if v.TimestampHeader != "" {
+		n, err := strconv.ParseInt(header.Get(v.TimestampHeader), 10, 64)
+		if err != nil {
+			return ErrInvalidTimestamp
+		}
+		timestamp = time.Unix(n, 0)
+		if d := now.Sub(timestamp); d > v.tolerance() || d < -v.tolerance() {
+			return ErrInvalidTimestamp
+		}
+	}
+	signatureHeader := v.SignatureHeader
+	if signatureHeader == "" {
+		signatureHeader = defaultSignatureHeader
+	}
+	var (
+		signature = strings.TrimPrefix(header.Get(signatureHeader), signaturePrefix)
+		expected  = strings.TrimPrefix(v.Sign(body, timestamp), signaturePrefix)
+	)
+	if !hmac.Equal([]byte(signature), []byte(expected)) {
+		return ErrInvalidSignature
+	}
+	return v.remember(expected, now)
+}
+
+// remember records the signature, returning ErrReplayed if it was already
+// seen within twice the tolerance. Older signatures are forgotten, which is
+// only safe when timestamps outside the tolerance are rejected.
+func (v *HMACVerifier) remember(signature string, now time.Time) error {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+	if v.seen == nil {
+		v.seen = make(map[string]time.Time)
+	}
+	for s, t := range v.seen {
+		if now.Sub(t) > 2*v.tolerance() {
+			delete(v.seen, s)
+		}
+	}
+	if _, ok := v.seen[signature]; ok {
+		return ErrReplayed
+	}
+	v.seen[signature] = now
+	return nil
+}
@@ -0,0 +1,15 @@
+package herald
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+)
+
+// newID generates a random identifier.
+func newID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,159 @@
+package herald
+
+// IndexFunc returns the keys a client should be indexed under.
+type IndexFunc func(client *Client) []string
+
+type index struct {
+	fn         IndexFunc
+	clients    map[string][]*Client
+	clientKeys map[*Client][]string
+}
+
+func (i *index) add(c *Client) {
+	keys := i.fn(c)
+	for _, k := range keys {
+		i.clients[k] = append(i.clients[k], c)
+	}
+	i.clientKeys[c] = keys
+}
+
+func (i *index) remove(c *Client) {
+	for _, k := range i.clientKeys[c] {
+		clients := i.clients[k]
+		for j, v := range clients {
+			if v == c {
+				clients = append(clients[:j], clients[j+1:]...)
+				break
+			}
+		}
+		if len(clients) == 0 {
+			delete(i.clients, k)
+		} else {
+			i.clients[k] = clients
+		}
+	}
+	delete(i.clientKeys, c)
+}
+
+// addToIndexes adds the client to all indexes. The mutex must be held.
+func (h *Herald) addToIndexes(c *Client) {
+	for _, i := range h.indexes {
+		i.add(c)
+	}
+}
+
+// removeFromIndexes removes the client from all indexes. The mutex must be
+// held.
+func (h *Herald) removeFromIndexes(c *Client) {
+	for _, i := range h.indexes {
+		i.remove(c)
+	}
+}
+
+// AddIndex creates an index with the specified name. The provided function is
+// invoked once for each client when it connects and the client is indexed
+// under each of the returned keys, allowing lookups such as "all clients for
+// a user" to be performed without scanning every client. Adding an index with
+// an existing name replaces it.
+func (h *Herald) AddIndex(name string, fn IndexFunc) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	i := &index{
+		fn:         fn,
+		clients:    make(map[string][]*Client),
+		clientKeys: make(map[*Client][]string),
+	}
+	for _, c := range h.clients {
+		i.add(c)
+	}
+	if h.indexes == nil {
+		h.indexes = make(map[string]*index)
+	}
+	h.indexes[name] = i
+}
+
+// ClassifierFunc returns the keys a client with the specified data should be
+// indexed under.
+type ClassifierFunc func(data interface{}) []string
+
+// AddClassifier creates an index with the specified name whose keys are
+// derived from each client's Data. Unlike AddIndex, the client is indexed
+// again whenever its data is replaced with SetData(), keeping targeting in
+// sync with application state.
+func (h *Herald) AddClassifier(name string, fn ClassifierFunc) {
+	h.AddIndex(name, func(c *Client) []string {
+		return fn(c.Data)
+	})
+}
+
+// SetData replaces the client's data and updates the keys it is indexed
+// under in every index. The Data field must not be modified directly while
+// the client is connected if indexes depend on it.
+func (c *Client) SetData(data interface{}) {
+	c.UpdateData(func(interface{}) interface{} {
+		return data
+	})
+}
+
+// UpdateData replaces the client's data with the value returned by fn, which
+// receives the current data. The function is invoked while the client list is
+// locked, so the update does not race with the functions passed to
+// FindClients(), ForEachClient(), and AddIndex(), and the client is indexed
+// again with its new data. The function must not call other methods on the
+// Herald.
+func (c *Client) UpdateData(fn func(old interface{}) interface{}) {
+	h := c.herald
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	c.Data = fn(c.Data)
+	for _, i := range h.indexes {
+		if _, ok := i.clientKeys[c]; ok {
+			i.remove(c)
+			i.add(c)
+		}
+	}
+}
+
+// Lookup returns the clients indexed under the specified key in the named
+// index. Nil is returned if the index does not exist or no clients match.
+func (h *Herald) Lookup(name, key string) []*Client {
+	h.mutex.RLock()
+	defer h.mutex.RUnlock()
+	i, ok := h.indexes[name]
+	if !ok {
+		return nil
+	}
+	return append([]*Client(nil), i.clients[key]...)
+}
+
+// indexed determines whether the client is indexed under the key in the named
+// index.
+func (h *Herald) indexed(name, key string, c *Client) bool {
+	h.mutex.RLock()
+	defer h.mutex.RUnlock()
+	i, ok := h.indexes[name]
+	if !ok {
+		return false
+	}
+	for _, k := range i.clientKeys[c] {
+		if k == key {
+			return true
+		}
+	}
+	return false
+}
+
+// FindClients returns all connected clients for which the provided function
+// returns true. The function is invoked while the client list is locked, so
+// it must not call other methods on the Herald.
+func (h *Herald) FindClients(fn func(client *Client) bool) []*Client {
+	h.mutex.RLock()
+	defer h.mutex.RUnlock()
+	var clients []*Client
+	for _, c := range h.clients {
+		if fn(c) {
+			clients = append(clients, c)
+		}
+	}
+	return clients
+}
@@ -0,0 +1,62 @@
+package herald
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestIndex(t *testing.T) {
+
+	// Create the server with an index on the client data
+	s := newTestServer()
+	defer s.herald.Close()
+	s.herald.AddIndex("data", func(c *Client) []string {
+		return []string{c.Data.(string)}
+	})
+
+	// Create a client and ensure it can be found
+	c := newTestClient(t, s)
+	if !reflect.DeepEqual(s.herald.Lookup("data", clientData), []*Client{c.client}) {
+		t.Fatal("index lookup does not match")
+	}
+	if !reflect.DeepEqual(s.herald.FindClients(func(v *Client) bool {
+		return v.Data == clientData
+	}), []*Client{c.client}) {
+		t.Fatal("client search does not match")
+	}
+
+	// Close the client and ensure it was removed from the index
+	c.close(s)
+	if v := s.herald.Lookup("data", clientData); v != nil {
+		t.Fatal("client was not removed from index")
+	}
+}
+
+func TestClassifier(t *testing.T) {
+
+	// Create the server with a classifier on the client data
+	s := newTestServer()
+	defer s.herald.Close()
+	s.herald.AddClassifier("data", func(data interface{}) []string {
+		return []string{data.(string)}
+	})
+
+	// Create a client, change its data, and ensure it was reindexed
+	c := newTestClient(t, s)
+	c.client.SetData("other")
+	if v := s.herald.Lookup("data", clientData); v != nil {
+		t.Fatal("client was not removed from old key")
+	}
+	if !reflect.DeepEqual(s.herald.Lookup("data", "other"), []*Client{c.client}) {
+		t.Fatal("client was not added to new key")
+	}
+
+	// Update the data based on its current value
+	c.client.UpdateData(func(old interface{}) interface{} {
+		return old.(string) + "2"
+	})
+	if !reflect.DeepEqual(s.herald.Lookup("data", "other2"), []*Client{c.client}) {
+		t.Fatal("client was not added to updated key")
+	}
+	c.close(s)
+}
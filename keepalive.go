@@ -0,0 +1,84 @@
+package herald
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// keepaliveHeader is the response header containing the keepalive interval
+// agreed with the client in milliseconds.
+const keepaliveHeader = "X-Herald-Keepalive"
+
+// negotiateKeepalive determines the keepalive interval for a client that
+// requested the specified interval, which is zero if it did not request one.
+func (h *Herald) negotiateKeepalive(requested time.Duration) time.Duration {
+	if h.KeepaliveInterval == 0 {
+		return 0
+	}
+	if requested == 0 {
+		return h.KeepaliveInterval
+	}
+	if h.MinKeepaliveInterval != 0 && requested < h.MinKeepaliveInterval {
+		return h.MinKeepaliveInterval
+	}
+	if h.MaxKeepaliveInterval != 0 && requested > h.MaxKeepaliveInterval {
+		return h.MaxKeepaliveInterval
+	}
+	return requested
+}
+
+// withKeepaliveHeader adds the agreed keepalive interval to the response
+// headers if keepalives are enabled. The provided headers are not modified.
+func withKeepaliveHeader(header http.Header, interval time.Duration) http.Header {
+	if interval == 0 {
+		return header
+	}
+	if header == nil {
+		header = http.Header{}
+	} else {
+		header = header.Clone()
+	}
+	header.Set(keepaliveHeader, strconv.FormatInt(interval.Milliseconds(), 10))
+	return header
+}
+
+// extendDeadline allows the client two keepalive intervals to send its next
+// message or respond to the next ping before it is considered dead. The read
+// deadline is cleared if keepalives are disabled.
+func (c *Client) extendDeadline() {
+	if c.keepalive == 0 {
+		c.conn.SetReadDeadline(time.Time{})
+		return
+	}
+	c.conn.SetReadDeadline(time.Now().Add(2 * c.keepalive))
+}
+
+// keepaliveLoop pings the client once every keepalive interval until it
+// disconnects.
+func (c *Client) keepaliveLoop() {
+	tickerChan, stop := c.herald.clock().NewTicker(c.keepalive)
+	defer stop()
+	for {
+		select {
+		case <-tickerChan:
+			c.conn.WriteControl(
+				websocket.PingMessage,
+				nil,
+				time.Now().Add(c.keepalive),
+			)
+		case <-c.closedChan:
+			return
+		}
+	}
+}
+
+// KeepaliveInterval returns the interval at which the client is pinged, as
+// agreed when it connected, or zero if keepalives are disabled. A client that
+// neither responds to a ping nor sends a message for twice this interval is
+// disconnected.
+func (c *Client) KeepaliveInterval() time.Duration {
+	return c.keepalive
+}
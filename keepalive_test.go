@@ -0,0 +1,77 @@
+package herald
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestNegotiateKeepalive(t *testing.T) {
+	h := &Herald{
+		KeepaliveInterval:    time.Minute,
+		MinKeepaliveInterval: 10 * time.Second,
+		MaxKeepaliveInterval: 5 * time.Minute,
+	}
+	for _, v := range []struct {
+		requested time.Duration
+		agreed    time.Duration
+	}{
+		{0, time.Minute},
+		{time.Second, 10 * time.Second},
+		{2 * time.Minute, 2 * time.Minute},
+		{time.Hour, 5 * time.Minute},
+	} {
+		if d := h.negotiateKeepalive(v.requested); d != v.agreed {
+			t.Fatalf("%s: %s != %s", v.requested, d, v.agreed)
+		}
+	}
+	if d := (&Herald{}).negotiateKeepalive(time.Minute); d != 0 {
+		t.Fatalf("%s != 0", d)
+	}
+}
+
+func TestHeraldKeepalive(t *testing.T) {
+
+	// Create the server with a short keepalive interval
+	s := newTestServer(func(h *Herald) {
+		h.KeepaliveInterval = time.Second
+		h.MinKeepaliveInterval = 50 * time.Millisecond
+	})
+	defer s.herald.Close()
+
+	// Connect a client requesting an interval below the minimum
+	s.clientAddedWG.Add(1)
+	var (
+		server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if _, err := s.herald.AddClient(w, r, clientData); err != nil {
+				t.Log(err)
+				t.Fail()
+			}
+		}))
+		addr            = strings.Replace(server.URL, "http", "ws", 1) + "?capabilities=keepalive=10"
+		conn, resp, err = websocket.DefaultDialer.Dial(addr, nil)
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	server.Close()
+	s.clientAddedWG.Wait()
+
+	// Ensure that the agreed interval was sent to the client
+	if v := resp.Header.Get(keepaliveHeader); v != "50" {
+		t.Fatalf("%s != 50", v)
+	}
+	if d := s.herald.Clients()[0].KeepaliveInterval(); d != 50*time.Millisecond {
+		t.Fatalf("%s != 50ms", d)
+	}
+
+	// Since the client never reads, it never responds to pings and should
+	// be disconnected
+	s.clientRemovedWG.Add(1)
+	s.clientRemovedWG.Wait()
+}
@@ -0,0 +1,29 @@
+package herald
+
+// exchangeKey passes the handshake message to KeyExchange, stores the derived
+// key, and sends the reply to the client.
+func (c *Client) exchangeKey(m *Message) error {
+	fn := c.herald.KeyExchange
+	if fn == nil {
+		return nil
+	}
+	key, reply, err := fn(c, m)
+	if err != nil {
+		return err
+	}
+	c.mutex.Lock()
+	c.key = key
+	c.mutex.Unlock()
+	if reply != nil {
+		return c.herald.Send(reply, []*Client{c})
+	}
+	return nil
+}
+
+// Key returns the key derived by KeyExchange during the handshake or nil if
+// no key was derived.
+func (c *Client) Key() []byte {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.key
+}
@@ -0,0 +1,52 @@
+package herald
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestHeraldKeyExchange(t *testing.T) {
+
+	// Create the server with a key exchange that uses the handshake data as
+	// the key and rejects empty data
+	s := newTestServer(func(h *Herald) {
+		h.KeyExchange = func(c *Client, m *Message) ([]byte, *Message, error) {
+			if string(m.Data) == "null" {
+				return nil, nil, errors.New("missing key")
+			}
+			return m.Data, newTestMessage(t, messageType2), nil
+		}
+	})
+	defer s.herald.Close()
+
+	// Perform the handshake and ensure that the key was stored and the
+	// reply sent
+	c1 := newTestClient(t, s)
+	m, err := NewMessage(messageType1, "key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c1.send(t, s, m)
+	c1.receive(t, s, &Message{Type: messageType2})
+	if k := c1.client.Key(); !bytes.Equal(k, []byte(`"key"`)) {
+		t.Fatalf("unexpected key: %s", k)
+	}
+	c1.close(s)
+
+	// Ensure that a client failing the exchange is disconnected
+	s.clientRemovedWG.Add(1)
+	c2 := newTestClient(t, s)
+	b, err := json.Marshal(newTestMessage(t, messageType1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c2.conn.WriteMessage(websocket.TextMessage, b); err != nil {
+		t.Fatal(err)
+	}
+	c2.verifyDisconnected(t)
+	s.clientRemovedWG.Wait()
+}
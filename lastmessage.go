@@ -0,0 +1,55 @@
+package herald
+
+import (
+	"time"
+)
+
+// LastMessage describes the most recent message of a type that was received
+// from a client and successfully handled.
+type LastMessage struct {
+
+	// Message is the message received from the client.
+	Message *Message
+
+	// Client is the client that sent the message. It may have disconnected
+	// since.
+	Client *Client
+
+	// Time indicates when the message was handled.
+	Time time.Time
+}
+
+// TrackLastMessage begins recording the last message of the specified type
+// received from a client so that it can be retrieved with LastMessage().
+// Messages are recorded once their handler succeeds. This method must be
+// called before Start().
+func (h *Herald) TrackLastMessage(messageType string) {
+	if h.lastMessages == nil {
+		h.lastMessages = make(map[string]*LastMessage)
+	}
+	h.lastMessages[messageType] = nil
+}
+
+// recordLastMessage stores the message if its type is being tracked.
+func (h *Herald) recordLastMessage(m *Message, c *Client) {
+	if _, ok := h.lastMessages[m.Type]; !ok {
+		return
+	}
+	l := &LastMessage{
+		Message: m,
+		Client:  c,
+		Time:    h.clock().Now(),
+	}
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	h.lastMessages[m.Type] = l
+}
+
+// LastMessage returns the last message of the specified type received from
+// a client or nil if none has been received or the type is not being tracked
+// (see TrackLastMessage).
+func (h *Herald) LastMessage(messageType string) *LastMessage {
+	h.mutex.RLock()
+	defer h.mutex.RUnlock()
+	return h.lastMessages[messageType]
+}
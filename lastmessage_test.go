@@ -0,0 +1,30 @@
+package herald
+
+import (
+	"testing"
+)
+
+func TestHeraldLastMessage(t *testing.T) {
+
+	// Create the server, tracking a single type
+	s := newTestServer(func(h *Herald) {
+		h.TrackLastMessage(messageType1)
+	})
+	defer s.herald.Close()
+	c := newTestClient(t, s)
+
+	// Send messages of both types and ensure only one is recorded
+	c.send(t, s, newTestMessage(t, messageType1))
+	c.send(t, s, newTestMessage(t, messageType2))
+	l := s.herald.LastMessage(messageType1)
+	if l == nil {
+		t.Fatal("message was not recorded")
+	}
+	if l.Message.Type != messageType1 || l.Client != c.client || l.Time.IsZero() {
+		t.Fatalf("unexpected last message: %+v", l)
+	}
+	if s.herald.LastMessage(messageType2) != nil {
+		t.Fatal("untracked message was recorded")
+	}
+	c.close(s)
+}
@@ -0,0 +1,60 @@
+package herald
+
+import (
+	"net/http"
+)
+
+// MaintenanceError is the reason passed to the function provided to
+// SetUpgradeError() for connections rejected while maintenance mode is
+// enabled. It wraps ErrMaintenance.
+type MaintenanceError struct {
+
+	// Message is the message provided to SetMaintenance().
+	Message *Message
+}
+
+// Error returns the text of ErrMaintenance.
+func (e *MaintenanceError) Error() string {
+	return ErrMaintenance.Error()
+}
+
+// Unwrap returns ErrMaintenance.
+func (e *MaintenanceError) Unwrap() error {
+	return ErrMaintenance
+}
+
+// SetMaintenance enables maintenance mode. While enabled, new connections are
+// rejected with 503 Service Unavailable and, unless SetUpgradeError() was
+// used to change the response, the JSON-encoded message in the response
+// body. If notify is true, the message is also sent to all connected clients
+// with Send(), which publishes it to every instance on the backplane, if one
+// was set. Maintenance mode itself only applies to this instance. Passing a
+// nil message disables maintenance mode.
+func (h *Herald) SetMaintenance(message *Message, notify bool) {
+	h.mutex.Lock()
+	h.maintenance = message
+	h.mutex.Unlock()
+	if message != nil && notify {
+		h.Send(message, nil)
+	}
+}
+
+// Maintenance returns the message provided to SetMaintenance() or nil if
+// maintenance mode is disabled.
+func (h *Herald) Maintenance() *Message {
+	h.mutex.RLock()
+	defer h.mutex.RUnlock()
+	return h.maintenance
+}
+
+// checkMaintenance rejects the request with a MaintenanceError if
+// maintenance mode is enabled.
+func (h *Herald) checkMaintenance(w http.ResponseWriter, r *http.Request) error {
+	m := h.Maintenance()
+	if m == nil {
+		return nil
+	}
+	err := &MaintenanceError{Message: m}
+	h.reject(w, r, http.StatusServiceUnavailable, err)
+	return err
+}
@@ -0,0 +1,65 @@
+package herald
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestHeraldMaintenance(t *testing.T) {
+
+	// Create the server and a client
+	var (
+		s = newTestServer()
+		c = newTestClient(t, s)
+		m = newTestMessage(t, messageType1)
+	)
+	defer s.herald.Close()
+
+	// Enable maintenance mode and ensure the client is notified
+	s.herald.SetMaintenance(m, true)
+	c.receive(t, s, m)
+
+	// Ensure that new connections are rejected with the message
+	var (
+		w = httptest.NewRecorder()
+		r = httptest.NewRequest(http.MethodGet, "/", nil)
+	)
+	if _, err := s.herald.AddClient(w, r, nil); !errors.Is(err, ErrMaintenance) {
+		t.Fatalf("%v != %v", err, ErrMaintenance)
+	}
+	if w.Code != http.StatusServiceUnavailable {
+		t.Fatalf("%d != %d", w.Code, http.StatusServiceUnavailable)
+	}
+	v := &Message{}
+	if err := json.NewDecoder(w.Body).Decode(v); err != nil {
+		t.Fatal(err)
+	}
+	if v.Type != m.Type {
+		t.Fatalf("%s != %s", v.Type, m.Type)
+	}
+
+	// Ensure that a custom function receives the maintenance message
+	var reason error
+	s.herald.SetUpgradeError(func(w http.ResponseWriter, r *http.Request, s int, err error) {
+		reason = err
+		w.WriteHeader(s)
+	})
+	w = httptest.NewRecorder()
+	s.herald.AddClient(w, r, nil)
+	var maintenanceErr *MaintenanceError
+	if !errors.As(reason, &maintenanceErr) || maintenanceErr.Message != m {
+		t.Fatalf("unexpected reason: %v", reason)
+	}
+	if w.Code != http.StatusServiceUnavailable {
+		t.Fatalf("%d != %d", w.Code, http.StatusServiceUnavailable)
+	}
+	s.herald.SetUpgradeError(nil)
+
+	// Disable maintenance mode and ensure new clients can connect
+	s.herald.SetMaintenance(nil, false)
+	newTestClient(t, s).close(s)
+	c.close(s)
+}
@@ -0,0 +1,70 @@
+package herald
+
+// closedSignal is a channel that is always closed.
+var closedSignal = func() chan struct{} {
+	c := make(chan struct{})
+	close(c)
+	return c
+}()
+
+// size returns the approximate number of bytes of memory used by the message
+// in the entry.
+func (o *outgoing) size() int64 {
+	if o.message == nil {
+		return 0
+	}
+	return int64(len(o.message.Type) + len(o.message.Data) + len(o.message.Signature))
+}
+
+// addQueuedBytes adjusts the number of bytes queued for all clients. Once the
+// total falls to BackplanePauseBytes or below, paused backplane intake
+// resumes.
+func (h *Herald) addQueuedBytes(n int64) {
+	h.memoryMutex.Lock()
+	defer h.memoryMutex.Unlock()
+	h.queuedBytes += n
+	if h.queuedBytes <= h.BackplanePauseBytes {
+		for _, c := range h.memoryWaiters {
+			close(c)
+		}
+		h.memoryWaiters = nil
+	}
+}
+
+// QueuedBytes returns the approximate number of bytes of messages waiting to
+// be written to all clients.
+func (h *Herald) QueuedBytes() int64 {
+	h.memoryMutex.Lock()
+	defer h.memoryMutex.Unlock()
+	return h.queuedBytes
+}
+
+// exceedsMemory determines whether queueing the entries would exceed
+// MaxQueuedBytes.
+func (h *Herald) exceedsMemory(entries []*outgoing) bool {
+	if h.MaxQueuedBytes == 0 {
+		return false
+	}
+	var n int64
+	for _, o := range entries {
+		n += o.size()
+	}
+	return h.QueuedBytes()+n > h.MaxQueuedBytes
+}
+
+// waitForMemory blocks while more than BackplanePauseBytes are queued or
+// until the Herald is closed. This is invoked before messages received from
+// the backplane are processed so that backpressure is applied to it.
+func (h *Herald) waitForMemory() {
+	h.memoryMutex.Lock()
+	c := closedSignal
+	if h.BackplanePauseBytes != 0 && h.queuedBytes > h.BackplanePauseBytes {
+		c = make(chan struct{})
+		h.memoryWaiters = append(h.memoryWaiters, c)
+	}
+	h.memoryMutex.Unlock()
+	select {
+	case <-c:
+	case <-h.closedChan:
+	}
+}
@@ -0,0 +1,57 @@
+package herald
+
+import (
+	"testing"
+	"time"
+)
+
+func TestHeraldMaxQueuedBytes(t *testing.T) {
+
+	// Create the server with a limit too small for any message
+	s := newTestServer(func(h *Herald) {
+		h.MaxQueuedBytes = 1
+	})
+	defer s.herald.Close()
+	c := newTestClient(t, s)
+
+	// Send a message and ensure that the client is disconnected
+	s.clientRemovedWG.Add(1)
+	if err := s.herald.Send(newTestMessage(t, messageType1), nil); err != nil {
+		t.Fatal(err)
+	}
+	s.clientRemovedWG.Wait()
+	c.verifyDisconnected(t)
+	drops := s.herald.RecentDrops()
+	if len(drops) != 1 || drops[0].Reason != "memory limit" {
+		t.Fatalf("unexpected drops: %+v", drops)
+	}
+	if n := s.herald.QueuedBytes(); n != 0 {
+		t.Fatalf("%d != 0", n)
+	}
+}
+
+func TestHeraldWaitForMemory(t *testing.T) {
+
+	// Queue more than the threshold and begin waiting
+	h := New()
+	h.BackplanePauseBytes = 10
+	h.addQueuedBytes(20)
+	doneChan := make(chan struct{})
+	go func() {
+		defer close(doneChan)
+		h.waitForMemory()
+	}()
+	select {
+	case <-doneChan:
+		t.Fatal("wait returned above the threshold")
+	case <-time.After(10 * time.Millisecond):
+	}
+
+	// Fall below the threshold and ensure that the wait ends
+	h.addQueuedBytes(-15)
+	select {
+	case <-doneChan:
+	case <-time.After(receiveTimeout):
+		t.Fatal("timeout reached")
+	}
+}
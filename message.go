@@ -2,6 +2,9 @@ package herald
 
 import (
 	"encoding/json"
+	"time"
+
+	"github.com/gorilla/websocket"
 )
 
 // Message stores information for broadcasting to other clients. The Client
@@ -10,6 +13,41 @@ import (
 type Message struct {
 	Type string          `json:"type"`
 	Data json.RawMessage `json:"data"`
+
+	// Seq is a sequence number assigned by helpers such as State. It is
+	// omitted if zero. Sequence numbers on messages received from clients
+	// are discarded.
+	Seq uint64 `json:"seq,omitempty"`
+
+	// Signature is set when the message is sent to clients if the Herald
+	// has a Signer. The signature covers the type, sequence number, data,
+	// and DeliverAt of the message. Signatures on messages received from
+	// clients are discarded.
+	Signature []byte `json:"sig,omitempty"`
+
+	// DeliverAt is the server time at which clients should present the
+	// message, in milliseconds since the Unix epoch, so that a broadcast can
+	// be presented simultaneously despite differences in network latency.
+	// Clients convert it to their own clock using the offset estimated with
+	// TimeSyncMessageType. It is omitted if zero and discarded on messages
+	// received from clients.
+	DeliverAt int64 `json:"deliver_at,omitempty"`
+
+	// Key is an optional coalescing key. If a message with a key is queued
+	// for a client that has not yet received an earlier message with the same
+	// key, the earlier message is discarded. The key is not sent to clients.
+	Key string `json:"-"`
+
+	// Retain indicates that the message should be retained when broadcast to
+	// all clients. Clients that connect later immediately receive the last
+	// retained message of each type. This field is not sent to clients.
+	Retain bool `json:"-"`
+
+	// Deadline specifies when the message becomes stale. If the message has
+	// not been written to a client by the deadline, it is discarded for that
+	// client instead. A zero value means the message never becomes stale.
+	// This field is not sent to clients.
+	Deadline time.Time `json:"-"`
 }
 
 // NewMessage creates a new Message instance of the specified type with the
@@ -25,3 +63,24 @@ func NewMessage(messageType string, v interface{}) (*Message, error) {
 	m.Data = json.RawMessage(b)
 	return m, nil
 }
+
+// decodeMessage decodes a message received from a client.
+func decodeMessage(messageType int, p []byte) (*Message, error) {
+	if messageType != websocket.TextMessage {
+		return nil, ErrUnsupportedMessageType
+	}
+	m := &Message{}
+	if err := json.Unmarshal(p, m); err != nil {
+		return nil, err
+	}
+	m.clearReserved()
+	return m, nil
+}
+
+// clearReserved clears the fields that only the server may set in a message
+// decoded from an untrusted source.
+func (m *Message) clearReserved() {
+	m.Seq = 0
+	m.Signature = nil
+	m.DeliverAt = 0
+}
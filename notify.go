@@ -0,0 +1,53 @@
+package herald
+
+import (
+	"context"
+	"encoding/json"
+)
+
+// WaitForNotificationFunc blocks until a notification is received and returns
+// its channel and payload. This matches the shape of the LISTEN/NOTIFY APIs
+// provided by PostgreSQL drivers, such as pgx's Conn.WaitForNotification().
+type WaitForNotificationFunc func(ctx context.Context) (channel, payload string, err error)
+
+// notificationMessage creates a message for a notification. Payloads that
+// are valid JSON are used as-is; other payloads are encoded as strings.
+func notificationMessage(messageType, payload string) (*Message, error) {
+	if json.Valid([]byte(payload)) {
+		return &Message{
+			Type: messageType,
+			Data: json.RawMessage(payload),
+		}, nil
+	}
+	return NewMessage(messageType, payload)
+}
+
+// RelayNotifications broadcasts notifications received from the provided
+// function to all clients until the context is cancelled, an error occurs, or
+// the Herald shuts down, in which case ErrClosed is returned.
+// The types map specifies the message type to use for each notification
+// channel; notifications on other channels are ignored. If types is nil, the
+// channel name is used as the message type.
+func (h *Herald) RelayNotifications(ctx context.Context, wait WaitForNotificationFunc, types map[string]string) error {
+	for {
+		channel, payload, err := wait(ctx)
+		if err != nil {
+			return err
+		}
+		messageType := channel
+		if types != nil {
+			t, ok := types[channel]
+			if !ok {
+				continue
+			}
+			messageType = t
+		}
+		m, err := notificationMessage(messageType, payload)
+		if err != nil {
+			return err
+		}
+		if err := h.Send(m, nil); err != nil {
+			return err
+		}
+	}
+}
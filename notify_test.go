@@ -0,0 +1,58 @@
+package herald
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestRelayNotifications(t *testing.T) {
+
+	// Create the server and a client
+	var (
+		s = newTestServer()
+		c = newTestClient(t, s)
+	)
+	defer s.herald.Close()
+
+	// Relay a notification on an ignored channel followed by one on a
+	// mapped channel
+	var (
+		notifications = [][2]string{
+			{"ignored", "{}"},
+			{"updates", "text"},
+		}
+		errDone = errors.New("done")
+		wait    = func(ctx context.Context) (string, string, error) {
+			if len(notifications) == 0 {
+				return "", "", errDone
+			}
+			n := notifications[0]
+			notifications = notifications[1:]
+			return n[0], n[1], nil
+		}
+		types = map[string]string{"updates": messageType1}
+	)
+	if err := s.herald.RelayNotifications(context.Background(), wait, types); err != errDone {
+		t.Fatal(err)
+	}
+	if m := c.receive(t, s, &Message{Type: messageType1}); string(m.Data) != `"text"` {
+		t.Fatalf("%s != \"text\"", m.Data)
+	}
+	c.close(s)
+}
+
+func TestRelayNotificationsClosed(t *testing.T) {
+
+	// Ensure that relaying stops once the Herald has shut down even though
+	// notifications continue to arrive
+	h := New()
+	h.Start()
+	h.Close()
+	wait := func(ctx context.Context) (string, string, error) {
+		return messageType1, "{}", nil
+	}
+	if err := h.RelayNotifications(context.Background(), wait, nil); err != ErrClosed {
+		t.Fatalf("%v != %v", err, ErrClosed)
+	}
+}
@@ -0,0 +1,123 @@
+package herald
+
+import (
+	"context"
+	"time"
+)
+
+// OutboxRecord is a message stored in a transactional outbox.
+type OutboxRecord struct {
+
+	// ID uniquely identifies the record.
+	ID string
+
+	// Message is the message to send.
+	Message *Message
+
+	// Index and Key optionally restrict the message to the clients indexed
+	// under the key in the named index (see SendToIndex). If Index is empty,
+	// the message is broadcast to all clients.
+	Index string
+	Key   string
+}
+
+// Outbox relays messages written to a transactional outbox, such as a
+// database table populated in the same transaction as the change that
+// produced the message.
+type Outbox struct {
+
+	// Poll returns the records that have not been acknowledged, in the order
+	// they should be sent.
+	Poll func(ctx context.Context) ([]*OutboxRecord, error)
+
+	// Ack marks the records as sent so that they are no longer returned by
+	// Poll, typically by deleting them or setting a flag.
+	Ack func(ctx context.Context, ids []string) error
+
+	// Interval specifies how long to wait between polls when no records
+	// are returned or an error occurs. If zero, one second is used.
+	Interval time.Duration
+
+	// ErrorHandler is invoked when Poll or Ack fail. This field is optional.
+	ErrorHandler func(err error)
+
+	pending map[string]struct{}
+}
+
+func (o *Outbox) reportError(err error) {
+	if o.ErrorHandler != nil {
+		o.ErrorHandler(err)
+	}
+}
+
+// relay polls for records, sends any that have not been sent, and acknowledges
+// them. It returns the number of records sent. If a record cannot be sent,
+// the error is returned and only the records sent before it are
+// acknowledged.
+func (o *Outbox) relay(ctx context.Context, h *Herald) (int, error) {
+	records, err := o.Poll(ctx)
+	if err != nil {
+		o.reportError(err)
+		return 0, nil
+	}
+
+	// Records that were handed to the Herald but failed to be acknowledged
+	// are skipped so that each record is sent exactly once
+	sent := 0
+	for _, r := range records {
+		if _, ok := o.pending[r.ID]; ok {
+			continue
+		}
+		if r.Index == "" {
+			err = h.Send(r.Message, nil)
+		} else {
+			err = h.SendToIndex(r.Index, r.Key, r.Message)
+		}
+		if err != nil {
+			break
+		}
+		o.pending[r.ID] = struct{}{}
+		sent++
+	}
+	if len(o.pending) == 0 {
+		return sent, err
+	}
+	ids := make([]string, 0, len(o.pending))
+	for id := range o.pending {
+		ids = append(ids, id)
+	}
+	if ackErr := o.Ack(ctx, ids); ackErr != nil {
+		o.reportError(ackErr)
+		return sent, err
+	}
+	o.pending = make(map[string]struct{})
+	return sent, err
+}
+
+// RelayOutbox sends records from the outbox until the context is cancelled.
+// Each record is sent exactly once by this Herald, even if acknowledging it
+// fails and the record is returned by Poll again. Records are only
+// acknowledged once they have been handed to the Herald; if the Herald is
+// shutting down, ErrClosed is returned and the remaining records are left
+// in the outbox.
+func (h *Herald) RelayOutbox(ctx context.Context, o *Outbox) error {
+	o.pending = make(map[string]struct{})
+	interval := o.Interval
+	if interval == 0 {
+		interval = time.Second
+	}
+	for {
+		n, err := o.relay(ctx, h)
+		if err != nil {
+			return err
+		}
+		if n != 0 {
+			continue
+		}
+		select {
+		case <-h.clock().After(interval):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
@@ -0,0 +1,88 @@
+package herald
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestOutbox(t *testing.T) {
+
+	// Create the server and a client
+	var (
+		s = newTestServer()
+		c = newTestClient(t, s)
+		m = newTestMessage(t, messageType1)
+	)
+	defer s.herald.Close()
+
+	// Create an outbox that returns a single record until it is
+	// acknowledged; the first acknowledgement fails
+	var (
+		acked    = false
+		ackCalls = 0
+		o        = &Outbox{
+			Poll: func(ctx context.Context) ([]*OutboxRecord, error) {
+				if acked {
+					return nil, nil
+				}
+				return []*OutboxRecord{{ID: "1", Message: m}}, nil
+			},
+			Ack: func(ctx context.Context, ids []string) error {
+				ackCalls++
+				if ackCalls == 1 {
+					return errors.New("test")
+				}
+				acked = true
+				return nil
+			},
+			Interval: time.Millisecond,
+		}
+	)
+	o.pending = make(map[string]struct{})
+
+	// The record should be sent on the first poll but not the second, even
+	// though it was returned again
+	if n, err := o.relay(context.Background(), s.herald); err != nil || n != 1 {
+		t.Fatalf("%d, %v != 1, nil", n, err)
+	}
+	if n, err := o.relay(context.Background(), s.herald); err != nil || n != 0 {
+		t.Fatalf("%d, %v != 0, nil", n, err)
+	}
+	if !acked {
+		t.Fatal("record was not acknowledged")
+	}
+	c.receive(t, s, m)
+	c.close(s)
+}
+
+func TestOutboxClosed(t *testing.T) {
+
+	// Create an outbox with two records and close the server
+	var (
+		s     = newTestServer()
+		acked []string
+		o     = &Outbox{
+			Poll: func(ctx context.Context) ([]*OutboxRecord, error) {
+				return []*OutboxRecord{
+					{ID: "1", Message: newTestMessage(t, messageType1)},
+					{ID: "2", Message: newTestMessage(t, messageType1), Index: "a", Key: "b"},
+				}, nil
+			},
+			Ack: func(ctx context.Context, ids []string) error {
+				acked = append(acked, ids...)
+				return nil
+			},
+		}
+	)
+	s.herald.Close()
+
+	// Ensure that relaying stops without acknowledging either record
+	if err := s.herald.RelayOutbox(context.Background(), o); !errors.Is(err, ErrClosed) {
+		t.Fatalf("%v != %v", err, ErrClosed)
+	}
+	if len(acked) != 0 {
+		t.Fatalf("unexpected acknowledgements: %v", acked)
+	}
+}
@@ -0,0 +1,228 @@
+package herald
+
+import (
+	"context"
+	"encoding/json"
+	"math/rand"
+	"net/http"
+	"runtime/metrics"
+	"runtime/pprof"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+
+	// maxProfileTypes is the number of distinct message types that are
+	// profiled and labelled individually. Since clients choose the types of
+	// the messages they send, later types are combined into
+	// profileOtherType to keep the number of entries bounded.
+	maxProfileTypes = 100
+
+	// profileOtherType is the type used for messages whose types were not
+	// among the first maxProfileTypes seen.
+	profileOtherType = "other"
+
+	// defaultProfileTop is the number of entries returned by the handler
+	// created with ProfileHandler() if the request does not specify one.
+	defaultProfileTop = 10
+)
+
+// ProfileStat is the cost attributed to a message type or client by the
+// sampled handler invocations and writes.
+type ProfileStat struct {
+
+	// Samples is the number of handler invocations and writes measured.
+	Samples uint64 `json:"samples"`
+
+	// Duration is the total wall-clock time spent in the samples, which
+	// includes time spent blocked, such as on a slow socket.
+	Duration time.Duration `json:"duration"`
+
+	// AllocBytes is the approximate number of bytes allocated during the
+	// samples. Allocations made concurrently by other goroutines are
+	// included, so it is only meaningful in aggregate.
+	AllocBytes uint64 `json:"alloc_bytes"`
+}
+
+func (s *ProfileStat) add(d time.Duration, allocs uint64) {
+	s.Samples++
+	s.Duration += d
+	s.AllocBytes += allocs
+}
+
+// TypeProfile is the cost attributed to a message type.
+type TypeProfile struct {
+	Type string `json:"type"`
+	ProfileStat
+}
+
+// ClientProfile is the cost attributed to a connected client.
+type ClientProfile struct {
+	Client     *Client           `json:"-"`
+	Group      string            `json:"group,omitempty"`
+	Attributes map[string]string `json:"attributes,omitempty"`
+	ProfileStat
+}
+
+// Profile contains the message types and clients with the highest sampled
+// cost, most expensive first.
+type Profile struct {
+	Types   []*TypeProfile   `json:"types"`
+	Clients []*ClientProfile `json:"clients"`
+}
+
+// profiler accumulates the samples taken when ProfileSampleRate is set.
+// Clients are removed when they disconnect.
+type profiler struct {
+	mutex   sync.Mutex
+	known   map[string]struct{}
+	types   map[string]*ProfileStat
+	clients map[*Client]*ProfileStat
+}
+
+// typeName returns the name used to profile and label the message type.
+func (p *profiler) typeName(messageType string) string {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if _, ok := p.known[messageType]; ok {
+		return messageType
+	}
+	if len(p.known) == maxProfileTypes {
+		return profileOtherType
+	}
+	if p.known == nil {
+		p.known = make(map[string]struct{})
+	}
+	p.known[messageType] = struct{}{}
+	return messageType
+}
+
+// record adds a sample for the message type and client.
+func (p *profiler) record(c *Client, messageType string, d time.Duration, allocs uint64) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if p.types == nil {
+		p.types = make(map[string]*ProfileStat)
+		p.clients = make(map[*Client]*ProfileStat)
+	}
+	s, ok := p.types[messageType]
+	if !ok {
+		s = &ProfileStat{}
+		p.types[messageType] = s
+	}
+	s.add(d, allocs)
+	s, ok = p.clients[c]
+	if !ok {
+		s = &ProfileStat{}
+		p.clients[c] = s
+	}
+	s.add(d, allocs)
+}
+
+// forget removes the samples for the client.
+func (p *profiler) forget(c *Client) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	delete(p.clients, c)
+}
+
+// allocBytes returns the total number of bytes allocated by the process.
+func allocBytes() uint64 {
+	s := []metrics.Sample{{Name: "/gc/heap/allocs:bytes"}}
+	metrics.Read(s)
+	if s[0].Value.Kind() != metrics.KindUint64 {
+		return 0
+	}
+	return s[0].Value.Uint64()
+}
+
+// withLabels invokes fn, which handles or writes the message for the client,
+// with pprof labels identifying the message type and the client's group if
+// ProfileLabels is set, and measures it if it is chosen as a sample.
+func (h *Herald) withLabels(c *Client, m *Message, fn func()) {
+	sample := h.ProfileSampleRate > 0 && rand.Float64() < h.ProfileSampleRate
+	if !h.ProfileLabels && !sample {
+		fn()
+		return
+	}
+	messageType := h.profiler.typeName(m.Type)
+	run := fn
+	if sample {
+		run = func() {
+			var (
+				allocs = allocBytes()
+				start  = time.Now()
+			)
+			fn()
+			h.profiler.record(c, messageType, time.Since(start), allocBytes()-allocs)
+		}
+	}
+	if !h.ProfileLabels {
+		run()
+		return
+	}
+	pprof.Do(c.ctx, pprof.Labels(
+		"herald_type", messageType,
+		"herald_group", c.group,
+	), func(context.Context) {
+		run()
+	})
+}
+
+// Profile returns up to n of the message types and connected clients with
+// the highest cost sampled since the Herald was started, ranked by duration.
+// Samples are only taken if ProfileSampleRate is set.
+func (h *Herald) Profile(n int) *Profile {
+	p := &Profile{
+		Types:   []*TypeProfile{},
+		Clients: []*ClientProfile{},
+	}
+	h.profiler.mutex.Lock()
+	for t, s := range h.profiler.types {
+		p.Types = append(p.Types, &TypeProfile{Type: t, ProfileStat: *s})
+	}
+	for c, s := range h.profiler.clients {
+		p.Clients = append(p.Clients, &ClientProfile{Client: c, ProfileStat: *s})
+	}
+	h.profiler.mutex.Unlock()
+	sort.Slice(p.Types, func(i, j int) bool {
+		return p.Types[i].Duration > p.Types[j].Duration
+	})
+	sort.Slice(p.Clients, func(i, j int) bool {
+		return p.Clients[i].Duration > p.Clients[j].Duration
+	})
+	if len(p.Types) > n {
+		p.Types = p.Types[:n]
+	}
+	if len(p.Clients) > n {
+		p.Clients = p.Clients[:n]
+	}
+	for _, v := range p.Clients {
+		v.Group = v.Client.group
+		v.Attributes = v.Client.Attributes()
+	}
+	return p
+}
+
+// ProfileHandler creates an http.Handler that responds with the JSON-encoded
+// result of Profile(). The number of entries can be specified with the "n"
+// query parameter and defaults to ten. Like DiagnosticsHandler, it exposes
+// internal details and must be protected from public access.
+func (h *Herald) ProfileHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := defaultProfileTop
+		if v := r.URL.Query().Get("n"); v != "" {
+			i, err := strconv.Atoi(v)
+			if err != nil || i < 1 {
+				http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+				return
+			}
+			n = i
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(h.Profile(n))
+	})
+}
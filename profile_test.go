@@ -0,0 +1,68 @@
+package herald
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+)
+
+func TestHeraldProfile(t *testing.T) {
+
+	// Create the server with every message sampled and a client
+	var (
+		s = newTestServer(func(h *Herald) {
+			h.ProfileSampleRate = 1
+		})
+		c = newTestClient(t, s)
+	)
+	defer s.herald.Close()
+
+	// Send a message so that its handler is sampled and ensure that it is
+	// attributed to the type and the client; the snapshot ensures that the
+	// run loop has finished with the message
+	c.send(t, s, newTestMessage(t, messageType1))
+	s.herald.Snapshot()
+	p := s.herald.Profile(10)
+	if len(p.Types) != 1 || p.Types[0].Type != messageType1 || p.Types[0].Samples == 0 {
+		t.Fatalf("unexpected types: %+v", p.Types)
+	}
+	if len(p.Clients) != 1 || p.Clients[0].Client != c.client {
+		t.Fatalf("unexpected clients: %+v", p.Clients)
+	}
+
+	// Ensure that the handler returns the same profile
+	w := httptest.NewRecorder()
+	s.herald.ProfileHandler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/?n=1", nil))
+	v := &Profile{}
+	if err := json.NewDecoder(w.Body).Decode(v); err != nil {
+		t.Fatal(err)
+	}
+	if len(v.Types) != 1 || v.Types[0].Type != messageType1 {
+		t.Fatalf("unexpected types: %+v", v.Types)
+	}
+
+	// Ensure that the client is forgotten once it disconnects
+	c.close(s)
+	if p := s.herald.Profile(10); len(p.Clients) != 0 {
+		t.Fatalf("unexpected clients: %+v", p.Clients)
+	}
+}
+
+func TestProfilerTypeName(t *testing.T) {
+
+	// Ensure that types beyond the limit are combined
+	p := &profiler{}
+	for i := 0; i < maxProfileTypes; i++ {
+		if n := p.typeName(strconv.Itoa(i)); n != strconv.Itoa(i) {
+			t.Fatalf("%s != %d", n, i)
+		}
+	}
+	if n := p.typeName("new"); n != profileOtherType {
+		t.Fatalf("%s != %s", n, profileOtherType)
+	}
+	if n := p.typeName("0"); n != "0" {
+		t.Fatalf("%s != 0", n)
+	}
+}
@@ -0,0 +1,159 @@
+package herald
+
+import (
+	"encoding/json"
+	"io"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+
+	// maxPublishSize is the maximum size of a request body accepted by
+	// PublishHandler.
+	maxPublishSize = 1 << 20
+
+	// bucketPruneInterval specifies how often PublishHandler removes the
+	// rate limits of callers that have been idle long enough for their
+	// limits to be fully replenished.
+	bucketPruneInterval = time.Minute
+)
+
+// PublishHandler is an http.Handler that broadcasts messages submitted in the
+// body of POST requests. The body must contain a JSON-encoded message.
+//
+// When exposed to semi-trusted callers, KeyFunc, Rate, and AllowedTypes can be
+// used to limit how often each caller may publish and which message types
+// they may publish.
+type PublishHandler struct {
+
+	// Verifier authenticates requests before they are processed. If nil, all
+	// requests are accepted.
+	Verifier *HMACVerifier
+
+	// KeyFunc identifies the caller making the request, typically using an
+	// API key. Requests for which it returns an empty string are rejected.
+	// This must be set for rate limiting to be enforced.
+	KeyFunc func(r *http.Request) string
+
+	// Rate limits the number of requests per second for each caller. A value
+	// of zero disables rate limiting.
+	Rate float64
+
+	// Burst specifies how many requests a caller can make at once before the
+	// rate limit applies.
+	Burst int
+
+	// AllowedTypes restricts the message types that can be published. If
+	// nil, all types are permitted.
+	AllowedTypes []string
+
+	herald  *Herald
+	mutex   sync.Mutex
+	buckets map[string]*tokenBucket
+	pruned  time.Time
+}
+
+// PublishHandler creates a new handler for publishing messages over HTTP.
+func (h *Herald) PublishHandler() *PublishHandler {
+	return &PublishHandler{
+		herald: h,
+	}
+}
+
+// allow determines whether the caller is within their rate limit, returning
+// how long they must wait if not.
+func (p *PublishHandler) allow(key string) (bool, time.Duration) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	now := p.herald.clock().Now()
+	if p.buckets == nil {
+		p.buckets = make(map[string]*tokenBucket)
+	}
+	if now.Sub(p.pruned) >= bucketPruneInterval {
+		p.pruned = now
+		for k, b := range p.buckets {
+			if b.full(now) {
+				delete(p.buckets, k)
+			}
+		}
+	}
+	b, ok := p.buckets[key]
+	if !ok {
+		b = newTokenBucket(p.Rate, p.Burst, now)
+		p.buckets[key] = b
+	}
+	return b.allow(1, now)
+}
+
+func (p *PublishHandler) typeAllowed(messageType string) bool {
+	if p.AllowedTypes == nil {
+		return true
+	}
+	for _, t := range p.AllowedTypes {
+		if t == messageType {
+			return true
+		}
+	}
+	return false
+}
+
+// ServeHTTP processes a request to publish a message.
+func (p *PublishHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	var key string
+	if p.KeyFunc != nil {
+		key = p.KeyFunc(r)
+		if key == "" {
+			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			return
+		}
+	}
+	b, err := io.ReadAll(io.LimitReader(r.Body, maxPublishSize+1))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if len(b) > maxPublishSize {
+		http.Error(w, http.StatusText(http.StatusRequestEntityTooLarge), http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	// Verify the request before applying the rate limit so that forged
+	// requests cannot exhaust a legitimate caller's limit
+	if p.Verifier != nil {
+		if err := p.Verifier.Verify(r.Header, b); err != nil {
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+	}
+	if key != "" && p.Rate > 0 {
+		if ok, d := p.allow(key); !ok {
+			p.herald.reportRequestAbuse(r, AbuseRateLimited)
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(d.Seconds()))))
+			http.Error(w, http.StatusText(http.StatusTooManyRequests), http.StatusTooManyRequests)
+			return
+		}
+	}
+	m := &Message{}
+	if err := json.Unmarshal(b, m); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	m.clearReserved()
+	if !p.typeAllowed(m.Type) {
+		http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+		return
+	}
+	if err := p.herald.Send(m, nil); err != nil {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
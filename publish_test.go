@@ -0,0 +1,239 @@
+package herald
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"testing/iotest"
+	"time"
+)
+
+func TestPublishHandler(t *testing.T) {
+
+	// Create the server, a client, and a signed publish handler
+	var (
+		s = newTestServer()
+		c = newTestClient(t, s)
+		p = s.herald.PublishHandler()
+		v = &HMACVerifier{
+			Secret:          []byte("secret"),
+			TimestampHeader: "X-Timestamp",
+		}
+		body = []byte(`{"type":"` + messageType1 + `","data":null}`)
+		now  = time.Now()
+	)
+	defer s.herald.Close()
+	p.Verifier = v
+
+	// Create a request with the specified signature
+	newRequest := func(signature string) *http.Request {
+		r := httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(body))
+		r.Header.Set("X-Timestamp", strconv.FormatInt(now.Unix(), 10))
+		r.Header.Set(defaultSignatureHeader, signature)
+		return r
+	}
+
+	// Ensure that a valid request is accepted once and that replays and
+	// invalid signatures are rejected
+	for i, v := range []struct {
+		signature string
+		status    int
+	}{
+		{signature: v.Sign(body, now), status: http.StatusAccepted},
+		{signature: v.Sign(body, now), status: http.StatusUnauthorized},
+		{signature: "sha256=00", status: http.StatusUnauthorized},
+	} {
+		w := httptest.NewRecorder()
+		p.ServeHTTP(w, newRequest(v.signature))
+		if w.Code != v.status {
+			t.Fatalf("%d: %d != %d", i, w.Code, v.status)
+		}
+	}
+	c.receive(t, s, &Message{Type: messageType1})
+	c.close(s)
+}
+
+func TestPublishHandlerLimits(t *testing.T) {
+
+	// Create the server and a publish handler that allows a single request
+	// per hour for each caller and permits only one message type
+	var (
+		s = newTestServer(func(h *Herald) {
+			h.AbusePolicy = &AbusePolicy{}
+		})
+		p = s.herald.PublishHandler()
+	)
+	defer s.herald.Close()
+	p.KeyFunc = func(r *http.Request) string {
+		return r.Header.Get("X-Key")
+	}
+	p.Rate = 1.0 / 3600
+	p.AllowedTypes = []string{messageType1}
+
+	// Create a request from the specified caller
+	newRequest := func(key, messageType string) *http.Request {
+		body := []byte(`{"type":"` + messageType + `","data":null}`)
+		r := httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(body))
+		r.Header.Set("X-Key", key)
+		return r
+	}
+	for i, v := range []struct {
+		key         string
+		messageType string
+		status      int
+	}{
+		{key: "", messageType: messageType1, status: http.StatusUnauthorized},
+		{key: "1", messageType: messageType1, status: http.StatusAccepted},
+		{key: "1", messageType: messageType1, status: http.StatusTooManyRequests},
+		{key: "2", messageType: messageType2, status: http.StatusForbidden},
+	} {
+		w := httptest.NewRecorder()
+		p.ServeHTTP(w, newRequest(v.key, v.messageType))
+		if w.Code != v.status {
+			t.Fatalf("%d: %d != %d", i, w.Code, v.status)
+		}
+	}
+
+	// Ensure that the rate-limited request was added to the abuse score
+	if v := s.herald.AbuseScore("192.0.2.1"); v != 1 {
+		t.Fatalf("%v != 1", v)
+	}
+}
+
+func TestPublishHandlerVerifyBeforeLimit(t *testing.T) {
+
+	// Create the server and a signed publish handler that allows a single
+	// request per hour for each caller
+	var (
+		clock = newFakeClock()
+		s     = newTestServer(func(h *Herald) {
+			h.Clock = clock
+		})
+		p    = s.herald.PublishHandler()
+		v    = &HMACVerifier{Secret: []byte("secret")}
+		body = []byte(`{"type":"` + messageType1 + `","data":null}`)
+	)
+	defer s.herald.Close()
+	p.Verifier = v
+	p.KeyFunc = func(r *http.Request) string {
+		return r.Header.Get("X-Key")
+	}
+	p.Rate = 1.0 / 3600
+
+	// Create a request from the specified caller with the signature
+	newRequest := func(key, signature string) *http.Request {
+		r := httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(body))
+		r.Header.Set("X-Key", key)
+		r.Header.Set(defaultSignatureHeader, signature)
+		return r
+	}
+
+	// Ensure that a forged request does not consume the caller's limit
+	for i, v := range []struct {
+		key       string
+		signature string
+		status    int
+	}{
+		{key: "1", signature: "sha256=00", status: http.StatusUnauthorized},
+		{key: "1", signature: v.Sign(body, time.Time{}), status: http.StatusAccepted},
+		{key: "2", signature: v.Sign(append(body, ' '), time.Time{}), status: http.StatusUnauthorized},
+	} {
+		w := httptest.NewRecorder()
+		p.ServeHTTP(w, newRequest(v.key, v.signature))
+		if w.Code != v.status {
+			t.Fatalf("%d: %d != %d", i, w.Code, v.status)
+		}
+	}
+	if n := len(p.buckets); n != 1 {
+		t.Fatalf("%d != 1", n)
+	}
+
+	// Once the first caller's limit is replenished, ensure that its bucket
+	// is removed when the next caller is limited
+	p.Verifier = nil
+	clock.Advance(2 * time.Hour)
+	w := httptest.NewRecorder()
+	p.ServeHTTP(w, newRequest("3", ""))
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("%d != %d", w.Code, http.StatusAccepted)
+	}
+	if _, ok := p.buckets["1"]; ok || len(p.buckets) != 1 {
+		t.Fatal("idle bucket was not removed")
+	}
+}
+
+func TestPublishHandlerClosed(t *testing.T) {
+
+	// Close the server and ensure that publishing fails
+	s := newTestServer()
+	p := s.herald.PublishHandler()
+	s.herald.Close()
+	w := httptest.NewRecorder()
+	p.ServeHTTP(w, httptest.NewRequest(
+		http.MethodPost,
+		"/",
+		bytes.NewReader([]byte(`{"type":"`+messageType1+`","data":null}`)),
+	))
+	if w.Code != http.StatusServiceUnavailable {
+		t.Fatalf("%d != %d", w.Code, http.StatusServiceUnavailable)
+	}
+}
+
+func TestPublishHandlerReserved(t *testing.T) {
+
+	// Create the server, a client, and a publish handler
+	var (
+		s = newTestServer()
+		c = newTestClient(t, s)
+		p = s.herald.PublishHandler()
+	)
+	defer s.herald.Close()
+
+	// Publish a message that sets the fields reserved for the server and
+	// ensure that they are cleared; a delivery time in the future would
+	// otherwise hold the message back
+	deliverAt := unixMillis(time.Now().Add(time.Hour))
+	w := httptest.NewRecorder()
+	p.ServeHTTP(w, httptest.NewRequest(
+		http.MethodPost,
+		"/",
+		bytes.NewReader([]byte(`{"type":"`+messageType1+`","data":null,"seq":5,"sig":"AAAA","deliver_at":`+
+			strconv.FormatInt(deliverAt, 10)+`}`)),
+	))
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("%d != %d", w.Code, http.StatusAccepted)
+	}
+	m := c.receive(t, s, &Message{Type: messageType1})
+	if m.Seq != 0 || m.Signature != nil || m.DeliverAt != 0 {
+		t.Fatalf("reserved fields were not cleared: %+v", m)
+	}
+	c.close(s)
+}
+
+func TestPublishHandlerBody(t *testing.T) {
+
+	// Ensure that an oversized body is rejected as too large and that a body
+	// that cannot be read is rejected as a bad request
+	var (
+		s = newTestServer()
+		p = s.herald.PublishHandler()
+	)
+	defer s.herald.Close()
+	for _, v := range []struct {
+		body   io.Reader
+		status int
+	}{
+		{bytes.NewReader(make([]byte, maxPublishSize+1)), http.StatusRequestEntityTooLarge},
+		{iotest.ErrReader(errors.New("test")), http.StatusBadRequest},
+	} {
+		w := httptest.NewRecorder()
+		p.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/", v.body))
+		if w.Code != v.status {
+			t.Fatalf("%d != %d", w.Code, v.status)
+		}
+	}
+}
@@ -0,0 +1,78 @@
+package herald
+
+// Publisher sends messages on behalf of a handler. The methods behave like
+// the Herald methods of the same name, which *Herald implements.
+type Publisher interface {
+	Send(message *Message, clients []*Client) error
+	SendAll(messages []*Message, clients []*Client) error
+	SendToGroup(group string, message *Message) error
+	SendExcept(message *Message, clients []*Client) error
+}
+
+// PublisherHandlerFunc processes a message received from a client, sending
+// any resulting messages with the provided Publisher.
+type PublisherHandlerFunc func(message *Message, client *Client, pub Publisher) error
+
+// handlerPublisher holds the messages sent by a handler until it returns.
+type handlerPublisher struct {
+	herald  *Herald
+	pending []func() error
+}
+
+func (p *handlerPublisher) add(fn func() error) error {
+	if p.herald.isClosing() {
+		return ErrClosed
+	}
+	p.pending = append(p.pending, fn)
+	return nil
+}
+
+func (p *handlerPublisher) Send(message *Message, clients []*Client) error {
+	return p.add(func() error {
+		return p.herald.Send(message, clients)
+	})
+}
+
+func (p *handlerPublisher) SendAll(messages []*Message, clients []*Client) error {
+	return p.add(func() error {
+		return p.herald.SendAll(messages, clients)
+	})
+}
+
+func (p *handlerPublisher) SendToGroup(group string, message *Message) error {
+	return p.add(func() error {
+		return p.herald.SendToGroup(group, message)
+	})
+}
+
+func (p *handlerPublisher) SendExcept(message *Message, clients []*Client) error {
+	return p.add(func() error {
+		return p.herald.SendExcept(message, clients)
+	})
+}
+
+// flush queues the messages held by the publisher in the order they were
+// sent.
+func (p *handlerPublisher) flush() error {
+	for _, fn := range p.pending {
+		if err := fn(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// HandleWithPublisher registers a handler for messages of the specified type
+// like Handle(). Messages sent with the Publisher passed to the handler are
+// held until it returns and are only queued for delivery if it succeeds, so
+// that a handler that fails, panics, or is retried does not send partial or
+// duplicate messages. This method must be called before Start().
+func (h *Herald) HandleWithPublisher(messageType string, fn PublisherHandlerFunc) {
+	h.Handle(messageType, func(m *Message, c *Client) error {
+		p := &handlerPublisher{herald: h}
+		if err := fn(m, c, p); err != nil {
+			return err
+		}
+		return p.flush()
+	})
+}
@@ -0,0 +1,46 @@
+package herald
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestHeraldHandleWithPublisher(t *testing.T) {
+
+	// Create the server with a handler that replies and fails on the first
+	// attempt and a handler that replies once
+	var (
+		s = newTestServer(func(h *Herald) {
+			h.HandlerRetryPolicy = &RetryPolicy{
+				MaxRetries:     1,
+				InitialBackoff: time.Millisecond,
+			}
+		})
+		attempts int
+	)
+	defer s.herald.Close()
+	s.herald.HandleWithPublisher(messageType1, func(m *Message, c *Client, pub Publisher) error {
+		if err := pub.Send(newTestMessage(t, messageType1), []*Client{c}); err != nil {
+			return err
+		}
+		attempts++
+		if attempts == 1 {
+			return errors.New("retry")
+		}
+		s.receivedWG.Done()
+		return nil
+	})
+	s.herald.HandleWithPublisher(messageType2, func(m *Message, c *Client, pub Publisher) error {
+		s.receivedWG.Done()
+		return pub.Send(newTestMessage(t, messageType2), []*Client{c})
+	})
+	c := newTestClient(t, s)
+
+	// Ensure that the reply from the failed attempt was discarded
+	c.send(t, s, newTestMessage(t, messageType1))
+	c.receive(t, s, &Message{Type: messageType1})
+	c.send(t, s, newTestMessage(t, messageType2))
+	c.receive(t, s, &Message{Type: messageType2})
+	c.close(s)
+}
@@ -0,0 +1,185 @@
+package herald
+
+import (
+	"errors"
+	"sync"
+)
+
+// queueSize is the maximum number of messages that can be waiting to be
+// written to a client before it is disconnected. Messages queued together
+// with SendAll() count as one.
+const queueSize = 10
+
+var (
+	// ErrSuperseded indicates that a queued message was discarded because a
+	// newer message with the same coalescing key was queued after it.
+	ErrSuperseded = errors.New("message superseded by a newer message")
+)
+
+// queue stores messages waiting to be written to a client. Entries are added
+// by the run loop and removed by the client's write loop. If account is not
+// nil, it is invoked with the change in the size of the queued messages.
+type queue struct {
+	mutex      sync.Mutex
+	entries    []*outgoing
+	bytes      int64
+	closed     bool
+	signalChan chan struct{}
+	account    func(n int64)
+}
+
+func newQueue(account func(n int64)) *queue {
+	return &queue{
+		signalChan: make(chan struct{}, 1),
+		account:    account,
+	}
+}
+
+// adjust records the change in the size of the queued messages.
+func (q *queue) adjust(added, removed []*outgoing) {
+	var n int64
+	for _, o := range added {
+		n += o.size()
+	}
+	for _, o := range removed {
+		n -= o.size()
+	}
+	q.bytes += n
+	if n != 0 && q.account != nil {
+		q.account(n)
+	}
+}
+
+func (q *queue) signal() {
+	select {
+	case q.signalChan <- struct{}{}:
+	default:
+	}
+}
+
+// slots returns the number of entries counted towards queueSize. Each group
+// of entries added together uses a single slot.
+func slots(entries []*outgoing) int {
+	n := 0
+	for _, o := range entries {
+		if !o.grouped {
+			n++
+		}
+	}
+	return n
+}
+
+// push adds the entries to the queue. Either all of the entries are added or
+// none of them are. If key is non-empty and a single entry is being added,
+// any queued entries with the same key are discarded.
+func (q *queue) push(entries []*outgoing, key string) DeliveryStatus {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	if q.closed {
+		return StatusGone
+	}
+	var (
+		kept       = q.entries
+		superseded []*outgoing
+	)
+	if key != "" && len(entries) == 1 {
+		kept = nil
+		for _, o := range q.entries {
+			if o.key == key {
+				superseded = append(superseded, o)
+			} else {
+				kept = append(kept, o)
+			}
+		}
+	}
+	if slots(kept)+1 > queueSize {
+		return StatusDropped
+	}
+	for i, o := range entries {
+		o.key = key
+		o.grouped = i > 0
+	}
+	q.entries = append(kept, entries...)
+	q.adjust(entries, superseded)
+	for _, o := range superseded {
+		o.complete(ErrSuperseded)
+	}
+	q.signal()
+	return StatusQueued
+}
+
+// mark adds an entry that does not contain a message to the end of the queue,
+// ignoring the size limit. False is returned if the queue is closed.
+func (q *queue) mark(o *outgoing) bool {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	if q.closed {
+		return false
+	}
+	q.entries = append(q.entries, o)
+	q.signal()
+	return true
+}
+
+// pop removes the first entry from the queue, waiting for one to be added if
+// necessary. Nil is returned once the queue is closed and empty.
+func (q *queue) pop() *outgoing {
+	for {
+		q.mutex.Lock()
+		if len(q.entries) != 0 {
+			o := q.entries[0]
+			q.entries = q.entries[1:]
+			q.adjust(nil, []*outgoing{o})
+			q.mutex.Unlock()
+			return o
+		}
+		closed := q.closed
+		q.mutex.Unlock()
+		if closed {
+			return nil
+		}
+		<-q.signalChan
+	}
+}
+
+// clear closes the queue and removes all of its entries, completing them
+// with the specified error.
+func (q *queue) clear(err error) {
+	q.mutex.Lock()
+	entries := q.entries
+	q.entries = nil
+	q.closed = true
+	q.adjust(nil, entries)
+	q.signal()
+	q.mutex.Unlock()
+	for _, o := range entries {
+		if o.flushChan != nil {
+			close(o.flushChan)
+			continue
+		}
+		o.complete(err)
+	}
+}
+
+// size returns the approximate number of bytes of the queued messages.
+func (q *queue) size() int64 {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	return q.bytes
+}
+
+// len returns the number of entries in the queue.
+func (q *queue) len() int {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	return len(q.entries)
+}
+
+// close prevents further entries from being added. Entries already in the
+// queue can still be removed.
+func (q *queue) close() {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	q.closed = true
+	q.signal()
+}
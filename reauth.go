@@ -0,0 +1,77 @@
+package herald
+
+import (
+	"encoding/json"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gorilla/websocket"
+)
+
+// ReauthMessageType is the type of the message sent by clients to present new
+// credentials over an existing connection, such as a refreshed token. The
+// data of the message contains "token", which is passed to ReauthHandler.
+const ReauthMessageType = "herald.reauth"
+
+// CloseUnauthorized is the close code sent to clients that fail to
+// re-authenticate before they are disconnected. The text of the close frame
+// is "unauthorized".
+const CloseUnauthorized = 4401
+
+// maxCloseText is the maximum length of the text in a close frame, which is
+// limited to 125 bytes including the code.
+const maxCloseText = 123
+
+type reauthMessage struct {
+	Token string `json:"token"`
+}
+
+// reauth passes the token in the message to ReauthHandler and replaces the
+// client's data with the result. If the token is rejected, the error is
+// reported and the client is disconnected with CloseUnauthorized. The error
+// itself is not sent to the client.
+func (h *Herald) reauth(m *Message, c *Client) {
+	v := &reauthMessage{}
+	err := json.Unmarshal(m.Data, v)
+	if err == nil {
+		var data interface{}
+		data, err = h.ReauthHandler(c, v.Token)
+		if err == nil {
+			c.SetData(data)
+			return
+		}
+	}
+	h.reportError(&ClientError{
+		Kind:    ErrorProtocol,
+		Client:  c,
+		Message: m,
+		Err:     err,
+	})
+	h.ReportAbuse(c, AbuseAuthFailure)
+	c.closeWithCode(CloseUnauthorized, "unauthorized")
+}
+
+// truncateCloseText shortens the text to fit in a close frame without
+// splitting a UTF-8 sequence.
+func truncateCloseText(text string) string {
+	if len(text) <= maxCloseText {
+		return text
+	}
+	i := maxCloseText
+	for i > 0 && !utf8.RuneStart(text[i]) {
+		i--
+	}
+	return text[:i]
+}
+
+// closeWithCode sends a close frame with the specified code and text to the
+// client and disconnects it immediately. The text is truncated if it does not
+// fit in the frame.
+func (c *Client) closeWithCode(code int, text string) {
+	c.conn.WriteControl(
+		websocket.CloseMessage,
+		websocket.FormatCloseMessage(code, truncateCloseText(text)),
+		time.Now().Add(closeFrameTimeout),
+	)
+	c.conn.Close()
+}
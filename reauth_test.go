@@ -0,0 +1,71 @@
+package herald
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const reauthToken = "valid"
+
+func TestHeraldReauth(t *testing.T) {
+
+	// Create the server with a handler that accepts a single token
+	var s *testServer
+	s = newTestServer(func(h *Herald) {
+		h.ReauthHandler = func(c *Client, token string) (interface{}, error) {
+			s.receivedWG.Done()
+			if token != reauthToken {
+				return nil, errors.New("invalid token")
+			}
+			return token, nil
+		}
+	})
+	defer s.herald.Close()
+	c := newTestClient(t, s)
+
+	// Present a valid token and ensure that the data was replaced once the
+	// next message is processed
+	m, err := NewMessage(ReauthMessageType, &reauthMessage{Token: reauthToken})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.send(t, s, m)
+	c.send(t, s, newTestMessage(t, messageType1))
+	if c.client.Data != reauthToken {
+		t.Fatalf("%v != %s", c.client.Data, reauthToken)
+	}
+
+	// Present an invalid token and ensure that the client is disconnected
+	// with the correct close code
+	m, err = NewMessage(ReauthMessageType, &reauthMessage{Token: "invalid"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.clientRemovedWG.Add(1)
+	c.send(t, s, m)
+	c.conn.SetReadDeadline(time.Now().Add(receiveTimeout))
+	_, _, err = c.conn.ReadMessage()
+	if e, ok := err.(*websocket.CloseError); !ok || e.Code != CloseUnauthorized || e.Text != "unauthorized" {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	s.clientRemovedWG.Wait()
+}
+
+func TestTruncateCloseText(t *testing.T) {
+	for _, v := range []struct {
+		text     string
+		expected string
+	}{
+		{"short", "short"},
+		{strings.Repeat("a", 130), strings.Repeat("a", 123)},
+		{strings.Repeat("a", 122) + "é", strings.Repeat("a", 122)},
+	} {
+		if s := truncateCloseText(v.text); s != v.expected {
+			t.Fatalf("%q != %q", s, v.expected)
+		}
+	}
+}
@@ -0,0 +1,123 @@
+package herald
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+var (
+	// ErrNotDelivered indicates that a message could not be written to one or
+	// more of its target clients.
+	ErrNotDelivered = errors.New("message not delivered to all clients")
+)
+
+// Receipt tracks the progress of writing a message to its target clients.
+type Receipt struct {
+	mutex    sync.Mutex
+	pending  int
+	failed   []*Client
+	err      error
+	doneChan chan struct{}
+}
+
+func newReceipt() *Receipt {
+	return &Receipt{
+		doneChan: make(chan struct{}),
+	}
+}
+
+// start sets the number of writes that need to complete.
+func (r *Receipt) start(n int) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.pending = n
+	if n == 0 {
+		close(r.doneChan)
+	}
+}
+
+// fail resolves the receipt immediately with the specified error.
+func (r *Receipt) fail(err error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.err = err
+	close(r.doneChan)
+}
+
+// complete records the outcome of a single write to a client.
+func (r *Receipt) complete(c *Client, err error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if err != nil && !r.hasFailed(c) {
+		r.failed = append(r.failed, c)
+	}
+	r.pending--
+	if r.pending == 0 {
+		close(r.doneChan)
+	}
+}
+
+func (r *Receipt) hasFailed(c *Client) bool {
+	for _, f := range r.failed {
+		if f == c {
+			return true
+		}
+	}
+	return false
+}
+
+// Done returns a channel that is closed once the message has been written to
+// all of its target clients or has failed.
+func (r *Receipt) Done() <-chan struct{} {
+	return r.doneChan
+}
+
+// Wait waits for the message to be written to all of its target clients. If
+// the context expires first, its error is returned. ErrNotDelivered is
+// returned if the message could not be written to one of the clients.
+func (r *Receipt) Wait(ctx context.Context) error {
+	select {
+	case <-r.doneChan:
+		return r.Err()
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Err returns ErrNotDelivered if the message could not be written to one or
+// more of its target clients or ErrClosed if the Herald was shutting down.
+// Calling Err before Done is closed returns nil.
+func (r *Receipt) Err() error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if r.err != nil {
+		return r.err
+	}
+	if len(r.failed) != 0 {
+		return ErrNotDelivered
+	}
+	return nil
+}
+
+// Failed returns the clients the message could not be written to.
+func (r *Receipt) Failed() []*Client {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return append([]*Client(nil), r.failed...)
+}
+
+// SendWithReceipt works like Send but returns a Receipt that can be used to
+// wait until the message has been written to the sockets of all target
+// clients.
+func (h *Herald) SendWithReceipt(message *Message, clients []*Client) *Receipt {
+	r := newReceipt()
+	if err := h.queueSend(&sendParams{
+		messages: []*Message{message},
+		clients:  clients,
+		receipt:  r,
+	}); err != nil {
+		r.fail(err)
+	}
+	return r
+}
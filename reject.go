@@ -0,0 +1,72 @@
+package herald
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+)
+
+// Additional codes used in the responses for rejected connections.
+const (
+	ErrorCodeForbidden    = "forbidden"
+	ErrorCodeUnavailable  = "unavailable"
+	ErrorCodeUpgradeError = "upgrade_error"
+)
+
+// errorCode returns the code for a rejected connection. The code from a
+// ConnectError is used if provided; otherwise it is derived from the status.
+func errorCode(status int, reason error) string {
+	var connectErr *ConnectError
+	if errors.As(reason, &connectErr) && connectErr.Code != "" {
+		return connectErr.Code
+	}
+	switch status {
+	case http.StatusBadRequest:
+		return ErrorCodeInvalid
+	case http.StatusUnauthorized:
+		return ErrorCodeUnauthorized
+	case http.StatusForbidden:
+		return ErrorCodeForbidden
+	case http.StatusTooManyRequests:
+		return ErrorCodeRateLimited
+	case http.StatusServiceUnavailable:
+		return ErrorCodeUnavailable
+	default:
+		return ErrorCodeUpgradeError
+	}
+}
+
+// writeRejection writes a JSON-encoded ErrorMessage describing why the
+// connection was rejected or, in maintenance mode, the maintenance message.
+// This is the default for SetUpgradeError().
+func writeRejection(w http.ResponseWriter, r *http.Request, status int, reason error) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	var maintenanceErr *MaintenanceError
+	if errors.As(reason, &maintenanceErr) {
+		json.NewEncoder(w).Encode(maintenanceErr.Message)
+		return
+	}
+	json.NewEncoder(w).Encode(&ErrorMessage{
+		Code:    errorCode(status, reason),
+		Message: reason.Error(),
+	})
+}
+
+// SetUpgradeError provides a function that writes the response for every
+// rejected connection, including failed upgrades, connections rejected by
+// UseConnect(), and connections made while shutting down or in maintenance
+// mode. By default, the response contains a JSON-encoded ErrorMessage.
+// Passing nil restores the default.
+func (h *Herald) SetUpgradeError(fn func(w http.ResponseWriter, r *http.Request, status int, reason error)) {
+	if fn == nil {
+		fn = writeRejection
+	}
+	h.upgrader.Error = fn
+}
+
+// reject writes the response for a rejected connection.
+func (h *Herald) reject(w http.ResponseWriter, r *http.Request, status int, reason error) {
+	h.upgrader.Error(w, r, status, reason)
+}
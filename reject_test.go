@@ -0,0 +1,50 @@
+package herald
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestHeraldUpgradeError(t *testing.T) {
+
+	// Create the server
+	s := newTestServer()
+	defer s.herald.Close()
+
+	// Ensure that a request that cannot be upgraded receives a structured
+	// response
+	var (
+		w = httptest.NewRecorder()
+		r = httptest.NewRequest(http.MethodGet, "/", nil)
+	)
+	if _, err := s.herald.AddClient(w, r, nil); err == nil {
+		t.Fatal("request was not rejected")
+	}
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("%d != %d", w.Code, http.StatusBadRequest)
+	}
+	e := &ErrorMessage{}
+	if err := json.NewDecoder(w.Body).Decode(e); err != nil {
+		t.Fatal(err)
+	}
+	if e.Code != ErrorCodeInvalid {
+		t.Fatalf("%s != %s", e.Code, ErrorCodeInvalid)
+	}
+
+	// Ensure that a custom function receives the rejection
+	var status int
+	s.herald.SetUpgradeError(func(w http.ResponseWriter, r *http.Request, s int, reason error) {
+		status = s
+		w.WriteHeader(http.StatusTeapot)
+	})
+	w = httptest.NewRecorder()
+	s.herald.AddClient(w, r, nil)
+	if status != http.StatusBadRequest {
+		t.Fatalf("%d != %d", status, http.StatusBadRequest)
+	}
+	if w.Code != http.StatusTeapot {
+		t.Fatalf("%d != %d", w.Code, http.StatusTeapot)
+	}
+}
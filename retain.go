@@ -0,0 +1,58 @@
+package herald
+
+// retain stores the message if it is marked as retained, replacing any
+// previously retained message of the same type. This is invoked by the run
+// loop for broadcast messages.
+func (h *Herald) retain(m *Message) {
+	if !m.Retain {
+		return
+	}
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	for i, v := range h.retained {
+		if v.Type == m.Type {
+			h.retained = append(h.retained[:i], h.retained[i+1:]...)
+			break
+		}
+	}
+	h.retained = append(h.retained, m)
+}
+
+// sendRetained queues all retained messages for a newly connected client.
+func (h *Herald) sendRetained(c *Client) {
+	h.mutex.RLock()
+	defer h.mutex.RUnlock()
+	for _, m := range h.retained {
+		c.enqueue([]*outgoing{
+			{
+				message: m,
+				client:  c,
+			},
+		})
+	}
+}
+
+// Retained returns the retained message for the specified type or nil if
+// there is none.
+func (h *Herald) Retained(messageType string) *Message {
+	h.mutex.RLock()
+	defer h.mutex.RUnlock()
+	for _, m := range h.retained {
+		if m.Type == messageType {
+			return m
+		}
+	}
+	return nil
+}
+
+// ClearRetained removes the retained message for the specified type, if any.
+func (h *Herald) ClearRetained(messageType string) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	for i, m := range h.retained {
+		if m.Type == messageType {
+			h.retained = append(h.retained[:i], h.retained[i+1:]...)
+			return
+		}
+	}
+}
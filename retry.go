@@ -0,0 +1,62 @@
+package herald
+
+import (
+	"time"
+)
+
+// RetryPolicy determines how many times and how often a failed operation is
+// retried.
+type RetryPolicy struct {
+
+	// MaxRetries specifies the maximum number of retries after the initial
+	// attempt fails.
+	MaxRetries int
+
+	// InitialBackoff specifies the delay before the first retry.
+	InitialBackoff time.Duration
+
+	// MaxBackoff caps the delay between retries. A value of zero means that
+	// the delay is not capped.
+	MaxBackoff time.Duration
+
+	// Multiplier is applied to the delay after each retry. If less than 1,
+	// a multiplier of 2 is used.
+	Multiplier float64
+}
+
+// backoff returns the delay before the specified retry, starting at zero.
+func (p *RetryPolicy) backoff(retry int) time.Duration {
+	multiplier := p.Multiplier
+	if multiplier < 1 {
+		multiplier = 2
+	}
+	d := float64(p.InitialBackoff)
+	for i := 0; i < retry; i++ {
+		d *= multiplier
+		if p.MaxBackoff != 0 && d > float64(p.MaxBackoff) {
+			break
+		}
+	}
+	if p.MaxBackoff != 0 && d > float64(p.MaxBackoff) {
+		return p.MaxBackoff
+	}
+	return time.Duration(d)
+}
+
+type permanentError struct {
+	err error
+}
+
+func (p *permanentError) Error() string {
+	return p.err.Error()
+}
+
+func (p *permanentError) Unwrap() error {
+	return p.err
+}
+
+// Permanent wraps an error returned from a handler to indicate that the
+// operation should not be retried.
+func Permanent(err error) error {
+	return &permanentError{err: err}
+}
@@ -0,0 +1,24 @@
+package herald
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRetryPolicyBackoff(t *testing.T) {
+	p := &RetryPolicy{
+		InitialBackoff: time.Second,
+		MaxBackoff:     5 * time.Second,
+	}
+	for i, d := range []time.Duration{
+		time.Second,
+		2 * time.Second,
+		4 * time.Second,
+		5 * time.Second,
+		5 * time.Second,
+	} {
+		if v := p.backoff(i); v != d {
+			t.Fatalf("%d: %s != %s", i, v, d)
+		}
+	}
+}
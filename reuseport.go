@@ -0,0 +1,77 @@
+package herald
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"syscall"
+)
+
+var (
+	// ErrReusePortUnsupported indicates that SO_REUSEPORT is not available on
+	// this platform.
+	ErrReusePortUnsupported = errors.New("SO_REUSEPORT is not supported")
+)
+
+// reusePortControl sets SO_REUSEPORT on a socket. It is nil on platforms
+// that do not support the option.
+var reusePortControl func(network, address string, c syscall.RawConn) error
+
+// ListenReusePort creates n listeners bound to the same address with
+// SO_REUSEPORT set so that the kernel distributes incoming connections
+// between them. Running an accept loop for each listener improves accept
+// throughput on multi-core machines during reconnect storms.
+// ErrReusePortUnsupported is returned if the option is not available.
+func ListenReusePort(network, address string, n int) ([]net.Listener, error) {
+	if reusePortControl == nil {
+		return nil, ErrReusePortUnsupported
+	}
+	var (
+		lc        = &net.ListenConfig{Control: reusePortControl}
+		listeners []net.Listener
+	)
+	for i := 0; i < n; i++ {
+		l, err := lc.Listen(context.Background(), network, address)
+		if err != nil {
+			for _, l := range listeners {
+				l.Close()
+			}
+			return nil, err
+		}
+		listeners = append(listeners, l)
+
+		// If the address used port zero, bind the remaining listeners to
+		// the port that was chosen
+		address = l.Addr().String()
+	}
+	return listeners, nil
+}
+
+// ServeReusePort serves HTTP requests with the server on n listeners bound
+// to its address with SO_REUSEPORT set (see ListenReusePort). The server's
+// handler decides whether connections are added to the same Herald or to
+// one of several shards. Like http.Server.ListenAndServe(), it always
+// returns an error, which is http.ErrServerClosed after the server is shut
+// down.
+func ServeReusePort(srv *http.Server, n int) error {
+	addr := srv.Addr
+	if addr == "" {
+		addr = ":http"
+	}
+	listeners, err := ListenReusePort("tcp", addr, n)
+	if err != nil {
+		return err
+	}
+	errChan := make(chan error, len(listeners))
+	for _, l := range listeners {
+		go func(l net.Listener) {
+			errChan <- srv.Serve(l)
+		}(l)
+	}
+	err = <-errChan
+	for _, l := range listeners {
+		l.Close()
+	}
+	return err
+}
@@ -0,0 +1,20 @@
+//go:build darwin || dragonfly || freebsd || netbsd || openbsd
+// +build darwin dragonfly freebsd netbsd openbsd
+
+package herald
+
+import (
+	"syscall"
+)
+
+func init() {
+	reusePortControl = func(network, address string, c syscall.RawConn) error {
+		var err error
+		if cErr := c.Control(func(fd uintptr) {
+			err = syscall.SetsockoptInt(int(fd), syscall.SOL_SOCKET, syscall.SO_REUSEPORT, 1)
+		}); cErr != nil {
+			return cErr
+		}
+		return err
+	}
+}
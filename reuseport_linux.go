@@ -0,0 +1,24 @@
+//go:build linux && !mips && !mipsle && !mips64 && !mips64le
+// +build linux,!mips,!mipsle,!mips64,!mips64le
+
+package herald
+
+import (
+	"syscall"
+)
+
+// soReusePort is the value of SO_REUSEPORT on Linux, which the syscall
+// package does not define for every architecture.
+const soReusePort = 0xf
+
+func init() {
+	reusePortControl = func(network, address string, c syscall.RawConn) error {
+		var err error
+		if cErr := c.Control(func(fd uintptr) {
+			err = syscall.SetsockoptInt(int(fd), syscall.SOL_SOCKET, soReusePort, 1)
+		}); cErr != nil {
+			return cErr
+		}
+		return err
+	}
+}
@@ -0,0 +1,26 @@
+package herald
+
+import (
+	"testing"
+)
+
+func TestListenReusePort(t *testing.T) {
+	listeners, err := ListenReusePort("tcp", "127.0.0.1:0", 2)
+	if err == ErrReusePortUnsupported {
+		t.Skip(err)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		for _, l := range listeners {
+			l.Close()
+		}
+	}()
+	if len(listeners) != 2 {
+		t.Fatalf("%d != 2", len(listeners))
+	}
+	if a, b := listeners[0].Addr().String(), listeners[1].Addr().String(); a != b {
+		t.Fatalf("%s != %s", a, b)
+	}
+}
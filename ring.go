@@ -0,0 +1,60 @@
+package herald
+
+import (
+	"hash/crc32"
+	"sort"
+	"strconv"
+)
+
+// ringReplicas is the number of points each member occupies on the ring.
+const ringReplicas = 64
+
+// hashRing assigns keys to members using consistent hashing so that adding
+// or removing a member only moves a small fraction of the keys.
+type hashRing struct {
+	points []uint32
+	owners map[uint32]string
+}
+
+// newHashRing creates a ring for the members. The members are sorted first so
+// that instances with the same members in a different order resolve hash
+// collisions the same way.
+func newHashRing(members []string) *hashRing {
+	r := &hashRing{
+		owners: make(map[uint32]string),
+	}
+	members = append([]string(nil), members...)
+	sort.Strings(members)
+	for _, m := range members {
+		for i := 0; i < ringReplicas; i++ {
+			p := crc32.ChecksumIEEE([]byte(m + "#" + strconv.Itoa(i)))
+			if _, ok := r.owners[p]; ok {
+				continue
+			}
+			r.points = append(r.points, p)
+			r.owners[p] = m
+		}
+	}
+	sort.Slice(r.points, func(i, j int) bool {
+		return r.points[i] < r.points[j]
+	})
+	return r
+}
+
+// owner returns the member that owns the key or an empty string if the ring
+// has no members.
+func (r *hashRing) owner(key string) string {
+	if len(r.points) == 0 {
+		return ""
+	}
+	var (
+		p = crc32.ChecksumIEEE([]byte(key))
+		i = sort.Search(len(r.points), func(i int) bool {
+			return r.points[i] >= p
+		})
+	)
+	if i == len(r.points) {
+		i = 0
+	}
+	return r.owners[r.points[i]]
+}
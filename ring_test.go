@@ -0,0 +1,42 @@
+package herald
+
+import (
+	"reflect"
+	"strconv"
+	"testing"
+)
+
+func TestHashRing(t *testing.T) {
+	var (
+		r1 = newHashRing([]string{"a", "b", "c"})
+		r2 = newHashRing([]string{"a", "b", "c", "d"})
+	)
+	if o := newHashRing(nil).owner("key"); o != "" {
+		t.Fatalf("%s != \"\"", o)
+	}
+
+	// Adding a member should only move keys to the new member
+	moved := 0
+	for i := 0; i < 1000; i++ {
+		var (
+			k  = strconv.Itoa(i)
+			o1 = r1.owner(k)
+			o2 = r2.owner(k)
+		)
+		if o1 != o2 {
+			if o2 != "d" {
+				t.Fatalf("key %s moved from %s to %s", k, o1, o2)
+			}
+			moved++
+		}
+	}
+	if moved == 0 || moved > 500 {
+		t.Fatalf("unexpected number of keys moved: %d", moved)
+	}
+
+	// The order of the members should not matter
+	r3 := newHashRing([]string{"c", "a", "b"})
+	if !reflect.DeepEqual(r1, r3) {
+		t.Fatal("ring depends on the order of the members")
+	}
+}
@@ -0,0 +1,109 @@
+package herald
+
+// removeFromRooms removes the client from all of the rooms it joined. The
+// mutex must be held.
+func (h *Herald) removeFromRooms(c *Client) {
+	for _, room := range h.clientRooms[c] {
+		h.removeFromRoom(c, room)
+	}
+	delete(h.clientRooms, c)
+}
+
+// removeFromRoom removes the client from the list of clients in the room. The
+// mutex must be held.
+func (h *Herald) removeFromRoom(c *Client, room string) {
+	clients := h.rooms[room]
+	for i, v := range clients {
+		if v == c {
+			clients = append(clients[:i], clients[i+1:]...)
+			break
+		}
+	}
+	if len(clients) == 0 {
+		delete(h.rooms, room)
+	} else {
+		h.rooms[room] = clients
+	}
+}
+
+// Join places the client in the specified room. Unlike groups, a client can
+// join and leave any number of rooms while it is connected and is removed
+// from all of them when it disconnects. Joining a room the client is already
+// in has no effect. ErrClientClosed is returned if the client has
+// disconnected.
+func (h *Herald) Join(c *Client, room string) error {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	select {
+	case <-c.closedChan:
+		return ErrClientClosed
+	default:
+	}
+	for _, r := range h.clientRooms[c] {
+		if r == room {
+			return nil
+		}
+	}
+	if h.rooms == nil {
+		h.rooms = make(map[string][]*Client)
+		h.clientRooms = make(map[*Client][]string)
+	}
+	h.rooms[room] = append(h.rooms[room], c)
+	h.clientRooms[c] = append(h.clientRooms[c], room)
+	return nil
+}
+
+// Leave removes the client from the specified room. Leaving a room the
+// client is not in has no effect.
+func (h *Herald) Leave(c *Client, room string) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	rooms := h.clientRooms[c]
+	for i, r := range rooms {
+		if r == room {
+			h.removeFromRoom(c, room)
+			rooms = append(rooms[:i], rooms[i+1:]...)
+			if len(rooms) == 0 {
+				delete(h.clientRooms, c)
+			} else {
+				h.clientRooms[c] = rooms
+			}
+			return
+		}
+	}
+}
+
+// Rooms returns the names of the rooms the client has joined, in the order
+// they were joined.
+func (h *Herald) Rooms(c *Client) []string {
+	h.mutex.RLock()
+	defer h.mutex.RUnlock()
+	return append([]string(nil), h.clientRooms[c]...)
+}
+
+// RoomClients returns the clients in the specified room.
+func (h *Herald) RoomClients(room string) []*Client {
+	h.mutex.RLock()
+	defer h.mutex.RUnlock()
+	return append([]*Client(nil), h.rooms[room]...)
+}
+
+// RoomCount returns the number of clients in the specified room without
+// copying the client list.
+func (h *Herald) RoomCount(room string) int {
+	h.mutex.RLock()
+	defer h.mutex.RUnlock()
+	return len(h.rooms[room])
+}
+
+// SendToRoom sends the message to all clients in the specified room. The room
+// is resolved when the message is delivered, so clients that join the room
+// before then also receive it. If a backplane is in use, the message is also
+// sent to the clients in the room on every other instance. ErrClosed is
+// returned if the Herald is shutting down.
+func (h *Herald) SendToRoom(room string, message *Message) error {
+	return h.queueSend(&sendParams{
+		messages: []*Message{message},
+		room:     room,
+	})
+}
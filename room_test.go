@@ -0,0 +1,69 @@
+package herald
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestHeraldSendToRoom(t *testing.T) {
+
+	// Create the server and two clients in a shared room, one of which also
+	// joins a second room
+	var (
+		s  = newTestServer()
+		c1 = newTestClient(t, s)
+		c2 = newTestClient(t, s)
+
+		m1 = newTestMessage(t, messageType1)
+		m2 = newTestMessage(t, messageType2)
+	)
+	defer s.herald.Close()
+	for _, err := range []error{
+		s.herald.Join(c1.client, "lobby"),
+		s.herald.Join(c1.client, "game"),
+		s.herald.Join(c1.client, "lobby"),
+		s.herald.Join(c2.client, "lobby"),
+	} {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if r := s.herald.Rooms(c1.client); !reflect.DeepEqual(r, []string{"lobby", "game"}) {
+		t.Fatalf("unexpected rooms: %v", r)
+	}
+	if r := s.herald.Snapshot().Rooms; !reflect.DeepEqual(r, map[string][]*Client{
+		"lobby": {c1.client, c2.client},
+		"game":  {c1.client},
+	}) {
+		t.Fatalf("unexpected snapshot rooms: %v", r)
+	}
+
+	// Send a message to each room; the second client must only receive the
+	// message sent to the lobby
+	s.herald.SendToRoom("game", m1)
+	s.herald.SendToRoom("lobby", m2)
+	c1.receive(t, s, m1)
+	c1.receive(t, s, m2)
+	c2.receive(t, s, m2)
+
+	// Leave the game and ensure that the room is removed
+	s.herald.Leave(c1.client, "game")
+	if n := s.herald.RoomCount("game"); n != 0 {
+		t.Fatalf("%d != 0", n)
+	}
+
+	// Close the clients and ensure they are removed from the lobby and can
+	// no longer join rooms
+	c1.close(s)
+	if n := s.herald.RoomCount("lobby"); n != 1 {
+		t.Fatalf("%d != 1", n)
+	}
+	if err := s.herald.Join(c1.client, "lobby"); !errors.Is(err, ErrClientClosed) {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	c2.close(s)
+	if n := len(s.herald.RoomClients("lobby")); n != 0 {
+		t.Fatalf("%d != 0", n)
+	}
+}
@@ -0,0 +1,123 @@
+package herald
+
+import (
+	"encoding/json"
+	"sort"
+)
+
+// ConvertFunc converts the data of a message between two adjacent versions
+// of its type.
+type ConvertFunc func(data json.RawMessage) (json.RawMessage, error)
+
+// TypeVersion describes one version of a message type.
+type TypeVersion struct {
+
+	// Version is the protocol version that introduced this version of the
+	// type.
+	Version int
+
+	// Schema optionally describes the data of the message, for example with
+	// JSON Schema, so that it can be published to client developers. It is
+	// not used to validate messages.
+	Schema json.RawMessage
+
+	// Upgrade converts data from the previous version of the type to this
+	// version. If nil, the data is unchanged.
+	Upgrade ConvertFunc
+
+	// Downgrade converts data from this version of the type to the previous
+	// version. If nil, the data is unchanged.
+	Downgrade ConvertFunc
+}
+
+// RegisterType declares the versions of a message type. Messages of the type
+// are sent and handled in the latest version; messages sent to clients that
+// negotiated an older protocol version (see Capabilities) are downgraded
+// before they are written and messages received from them are upgraded
+// before they are handled. Clients that did not specify a version are sent
+// the latest version. This method must be called before Start().
+func (h *Herald) RegisterType(messageType string, versions ...TypeVersion) {
+	if h.types == nil {
+		h.types = make(map[string][]TypeVersion)
+	}
+	v := append([]TypeVersion(nil), versions...)
+	sort.Slice(v, func(i, j int) bool {
+		return v[i].Version < v[j].Version
+	})
+	h.types[messageType] = v
+}
+
+// Schema returns the schema of the message type at the specified protocol
+// version or nil if the type was not registered.
+func (h *Herald) Schema(messageType string, version int) json.RawMessage {
+	versions := h.types[messageType]
+	if len(versions) == 0 {
+		return nil
+	}
+	return versions[versionIndex(versions, version)].Schema
+}
+
+// versionIndex returns the index of the latest version of a type that a
+// client speaking the protocol version understands. The oldest version is
+// used if the client is older than all of them.
+func versionIndex(versions []TypeVersion, version int) int {
+	if version == 0 {
+		return len(versions) - 1
+	}
+	for i := len(versions) - 1; i > 0; i-- {
+		if versions[i].Version <= version {
+			return i
+		}
+	}
+	return 0
+}
+
+// downgradeMessage converts a message being sent to the client to the
+// version of its type that the client understands. The original message is
+// not modified.
+func (c *Client) downgradeMessage(m *Message) (*Message, error) {
+	versions := c.herald.types[m.Type]
+	if len(versions) == 0 {
+		return m, nil
+	}
+	target := versionIndex(versions, c.capabilities.Version)
+	if target == len(versions)-1 {
+		return m, nil
+	}
+	var (
+		data = m.Data
+		err  error
+	)
+	for i := len(versions) - 1; i > target; i-- {
+		if fn := versions[i].Downgrade; fn != nil {
+			if data, err = fn(data); err != nil {
+				return nil, err
+			}
+		}
+	}
+	v := *m
+	v.Data = data
+	if c.herald.Signer != nil {
+		v.Signature = c.herald.Signer.Sign(signingPayload(&v))
+	}
+	return &v, nil
+}
+
+// upgradeMessage converts a message received from the client to the latest
+// version of its type.
+func (c *Client) upgradeMessage(m *Message) error {
+	versions := c.herald.types[m.Type]
+	if len(versions) == 0 {
+		return nil
+	}
+	for i := versionIndex(versions, c.capabilities.Version) + 1; i < len(versions); i++ {
+		if fn := versions[i].Upgrade; fn != nil {
+			data, err := fn(m.Data)
+			if err != nil {
+				return err
+			}
+			m.Data = data
+		}
+	}
+	return nil
+}
@@ -0,0 +1,65 @@
+package herald
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestRegisterType(t *testing.T) {
+
+	// Register a type whose data was renamed in the second version
+	h := New()
+	h.RegisterType(messageType1,
+		TypeVersion{
+			Version: 2,
+			Schema:  json.RawMessage(`"v2"`),
+			Upgrade: func(data json.RawMessage) (json.RawMessage, error) {
+				return json.RawMessage(`"new"`), nil
+			},
+			Downgrade: func(data json.RawMessage) (json.RawMessage, error) {
+				return json.RawMessage(`"old"`), nil
+			},
+		},
+		TypeVersion{
+			Version: 1,
+			Schema:  json.RawMessage(`"v1"`),
+		},
+	)
+	for _, v := range []struct {
+		version int
+		schema  string
+	}{
+		{0, `"v2"`},
+		{1, `"v1"`},
+		{2, `"v2"`},
+		{3, `"v2"`},
+	} {
+		if s := string(h.Schema(messageType1, v.version)); s != v.schema {
+			t.Fatalf("%d: %s != %s", v.version, s, v.schema)
+		}
+	}
+
+	// Ensure that messages are converted for old clients
+	var (
+		m      = &Message{Type: messageType1, Data: json.RawMessage(`"new"`)}
+		oldC   = &Client{herald: h, capabilities: &Capabilities{Version: 1}}
+		newC   = &Client{herald: h, capabilities: &Capabilities{Version: 2}}
+		d, err = oldC.downgradeMessage(m)
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(d.Data) != `"old"` || string(m.Data) != `"new"` {
+		t.Fatalf("unexpected data: %s, %s", d.Data, m.Data)
+	}
+	if d, _ := newC.downgradeMessage(m); d != m {
+		t.Fatal("message was converted for new client")
+	}
+	m = &Message{Type: messageType1, Data: json.RawMessage(`"old"`)}
+	if err := oldC.upgradeMessage(m); err != nil {
+		t.Fatal(err)
+	}
+	if string(m.Data) != `"new"` {
+		t.Fatalf("%s != \"new\"", m.Data)
+	}
+}
@@ -0,0 +1,218 @@
+package herald
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+)
+
+const (
+
+	// sessionHeader is the response header containing the ID of the session
+	// assigned to a client when SessionStore is set.
+	sessionHeader = "X-Herald-Session"
+
+	// sessionParam is the query parameter clients use to present the ID of
+	// their session when reconnecting.
+	sessionParam = "session"
+)
+
+var (
+	// ErrSessionIdentity indicates that a client presented the ID of a
+	// session saved for a different identity, as returned by
+	// SessionIdentity. The session is not resumed.
+	ErrSessionIdentity = errors.New("session belongs to a different identity")
+)
+
+// SessionStore saves the state of disconnected clients so that they can
+// resume their sessions when they reconnect. Sessions are identified by
+// random IDs sent to clients in the X-Herald-Session response header, which
+// clients present in the "session" query parameter when reconnecting. A new
+// ID is sent each time a session is resumed and the presented ID is deleted,
+// so each ID can only be used once. Since presenting an ID restores the
+// attributes of the session, IDs should be treated as secrets.
+// Implementations must be safe for concurrent use.
+type SessionStore interface {
+
+	// Load returns the data saved for the session with the specified ID or
+	// nil if there is none.
+	Load(ctx context.Context, id string) ([]byte, error)
+
+	// Save stores the data for the session with the specified ID, replacing
+	// any existing data.
+	Save(ctx context.Context, id string, data []byte) error
+
+	// Delete removes the session with the specified ID if it exists.
+	Delete(ctx context.Context, id string) error
+}
+
+// session identifies the session of a client.
+type session struct {
+	id       string
+	identity string
+	resumed  bool
+}
+
+// sessionData is the state saved for a session.
+type sessionData struct {
+	Identity      string            `json:"identity,omitempty"`
+	Attributes    map[string]string `json:"attributes,omitempty"`
+	Subscriptions []string          `json:"subscriptions,omitempty"`
+}
+
+// loadSession loads the session presented in the request. If the client did
+// not present one, it cannot be loaded, or it belongs to a different
+// identity, a new session is created. A resumed session is moved to a new ID.
+func (h *Herald) loadSession(r *http.Request) (*session, *sessionData) {
+	s := &session{id: newID()}
+	if h.SessionIdentity != nil {
+		s.identity = h.SessionIdentity(r)
+	}
+	id := r.URL.Query().Get(sessionParam)
+	if id == "" {
+		return s, nil
+	}
+	d, err := h.resumeSession(r.Context(), id, s)
+	if err != nil {
+		h.reportError(&ClientError{
+			Kind: ErrorSession,
+			Err:  err,
+		})
+		return s, nil
+	}
+	if d != nil {
+		s.resumed = true
+	}
+	return s, d
+}
+
+// resumeSession loads the session with the specified ID and, if it belongs
+// to the identity of the new session, saves it under the ID of the new
+// session and deletes the old one. Nil is returned if the session does not
+// exist.
+func (h *Herald) resumeSession(ctx context.Context, id string, s *session) (*sessionData, error) {
+	b, err := h.SessionStore.Load(ctx, id)
+	if err != nil || b == nil {
+		return nil, err
+	}
+	d := &sessionData{}
+	if err := json.Unmarshal(b, d); err != nil {
+		return nil, err
+	}
+	if d.Identity != s.identity {
+		return nil, ErrSessionIdentity
+	}
+	if err := h.SessionStore.Save(ctx, s.id, b); err != nil {
+		return nil, err
+	}
+	if err := h.SessionStore.Delete(ctx, id); err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+// withSessionHeader adds the ID of the session to the response headers if
+// the client has one. The provided headers are not modified.
+func withSessionHeader(header http.Header, s *session) http.Header {
+	if s == nil {
+		return header
+	}
+	if header == nil {
+		header = http.Header{}
+	} else {
+		header = header.Clone()
+	}
+	header.Set(sessionHeader, s.id)
+	return header
+}
+
+// restoreSession applies the attributes saved for the session to the client.
+func (c *Client) restoreSession(d *sessionData) {
+	for k, v := range d.Attributes {
+		c.SetAttribute(k, v)
+	}
+}
+
+// resubscribe subscribes the client to the states saved for its session.
+// States that no longer exist are skipped and subscriptions rejected by
+// CanSubscribe are reported.
+func (h *Herald) resubscribe(c *Client, d *sessionData) {
+	for _, name := range d.Subscriptions {
+		s := h.state(name)
+		if s == nil {
+			continue
+		}
+		if err := s.Subscribe(c); err != nil {
+			h.reportError(&ClientError{
+				Kind:   ErrorSession,
+				Client: c,
+				Err:    err,
+			})
+		}
+	}
+}
+
+// subscriptions returns the names of the states the client is subscribed to.
+func (h *Herald) subscriptions(c *Client) []string {
+	h.mutex.RLock()
+	states := h.states
+	h.mutex.RUnlock()
+	var names []string
+	for _, s := range states {
+		s.mutex.Lock()
+		if s.indexOf(c) != -1 {
+			names = append(names, s.name)
+		}
+		s.mutex.Unlock()
+	}
+	return names
+}
+
+// saveSession saves the state of the client to SessionStore. This is invoked
+// by the run loop before the client is unsubscribed from its states; the
+// store is written to in a separate goroutine so that the loop is not
+// blocked.
+func (h *Herald) saveSession(c *Client) {
+	if c.session == nil {
+		return
+	}
+	b, err := json.Marshal(&sessionData{
+		Identity:      c.session.identity,
+		Attributes:    c.Attributes(),
+		Subscriptions: h.subscriptions(c),
+	})
+	if err != nil {
+		h.reportError(&ClientError{
+			Kind:   ErrorSession,
+			Client: c,
+			Err:    err,
+		})
+		return
+	}
+	go func() {
+		if err := h.SessionStore.Save(context.Background(), c.session.id, b); err != nil {
+			h.reportError(&ClientError{
+				Kind:   ErrorSession,
+				Client: c,
+				Err:    err,
+			})
+		}
+	}()
+}
+
+// SessionID returns the ID of the client's session or an empty string if
+// SessionStore is not set.
+func (c *Client) SessionID() string {
+	if c.session == nil {
+		return ""
+	}
+	return c.session.id
+}
+
+// Resumed indicates whether the client presented the ID of a saved session
+// when it connected. If so, the attributes and State subscriptions of the
+// session were restored and SessionID returns the new ID of the session.
+func (c *Client) Resumed() bool {
+	return c.session != nil && c.session.resumed
+}
@@ -0,0 +1,92 @@
+// Package memory provides an in-process session store. Sessions do not
+// survive a restart of the process, so it is primarily useful for single
+// instances and tests.
+package memory
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+type entry struct {
+	data    []byte
+	expires time.Time
+}
+
+// Clock provides the current time. It is satisfied by herald.Clock.
+type Clock interface {
+	Now() time.Time
+}
+
+// Store implements herald.SessionStore using in-memory data structures.
+type Store struct {
+
+	// Clock provides the current time used to expire sessions. It should be
+	// set to the Clock of the Herald, if any. If nil, the system clock is
+	// used.
+	Clock Clock
+
+	mutex   sync.Mutex
+	ttl     time.Duration
+	entries map[string]*entry
+}
+
+func (s *Store) now() time.Time {
+	if s.Clock == nil {
+		return time.Now()
+	}
+	return s.Clock.Now()
+}
+
+// New creates a new in-memory session store. Sessions expire once they have
+// not been saved for the specified duration. A value of zero keeps sessions
+// indefinitely.
+func New(ttl time.Duration) *Store {
+	return &Store{
+		ttl:     ttl,
+		entries: make(map[string]*entry),
+	}
+}
+
+// Load returns the data saved for the session or nil if it does not exist or
+// has expired.
+func (s *Store) Load(ctx context.Context, id string) ([]byte, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	e, ok := s.entries[id]
+	if !ok {
+		return nil, nil
+	}
+	if !e.expires.IsZero() && !s.now().Before(e.expires) {
+		delete(s.entries, id)
+		return nil, nil
+	}
+	return append([]byte(nil), e.data...), nil
+}
+
+// Save stores the data for the session and removes any expired sessions.
+func (s *Store) Save(ctx context.Context, id string, data []byte) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	now := s.now()
+	for k, e := range s.entries {
+		if !e.expires.IsZero() && !now.Before(e.expires) {
+			delete(s.entries, k)
+		}
+	}
+	e := &entry{data: append([]byte(nil), data...)}
+	if s.ttl != 0 {
+		e.expires = now.Add(s.ttl)
+	}
+	s.entries[id] = e
+	return nil
+}
+
+// Delete removes the session if it exists.
+func (s *Store) Delete(ctx context.Context, id string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	delete(s.entries, id)
+	return nil
+}
@@ -0,0 +1,194 @@
+package herald
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/nathan-osman/go-herald/session/memory"
+)
+
+// dialSession connects a client that presents the specified session ID and
+// returns it along with the ID sent by the server.
+func dialSession(t *testing.T, s *testServer, id string) (*testClient, string) {
+	s.clientAddedWG.Add(1)
+	var (
+		clientChan = make(chan *Client, 1)
+		server     = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			c, err := s.herald.AddClient(w, r, clientData)
+			if err != nil {
+				t.Log(err)
+				t.Fail()
+				return
+			}
+			clientChan <- c
+		}))
+		addr            = strings.Replace(server.URL, "http", "ws", 1) + "?session=" + id
+		conn, resp, err = websocket.DefaultDialer.Dial(addr, nil)
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server.Close()
+	s.clientAddedWG.Wait()
+	return &testClient{client: <-clientChan, conn: conn}, resp.Header.Get(sessionHeader)
+}
+
+// waitForSession waits for the session with the specified ID to be saved.
+func waitForSession(t *testing.T, store SessionStore, id string) {
+	for i := 0; ; i++ {
+		b, err := store.Load(context.Background(), id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if b != nil {
+			return
+		}
+		if i == 100 {
+			t.Fatal("session was not saved")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestSession(t *testing.T) {
+
+	// Create the server with a session store and a state
+	var (
+		store = memory.New(0)
+		s     = newTestServer(func(h *Herald) {
+			h.SessionStore = store
+		})
+		st = s.herald.NewState("counter", func() (interface{}, error) {
+			return 1, nil
+		})
+	)
+	defer s.herald.Close()
+
+	// Connect a client without a session and ensure a new one is created
+	c, id := dialSession(t, s, "")
+	if id == "" || id != c.client.SessionID() {
+		t.Fatalf("%s != %s", id, c.client.SessionID())
+	}
+	if c.client.Resumed() {
+		t.Fatal("new session was resumed")
+	}
+
+	// Set an attribute, subscribe to the state, and disconnect
+	c.client.SetAttribute("user", "alice")
+	if err := st.Subscribe(c.client); err != nil {
+		t.Fatal(err)
+	}
+	c.receive(t, s, &Message{Type: "counter.snapshot"})
+	c.close(s)
+
+	// Wait for the session to be saved
+	waitForSession(t, store, id)
+
+	// Reconnect with the session and ensure it was restored under a new ID
+	c, v := dialSession(t, s, id)
+	if v == id || v != c.client.SessionID() {
+		t.Fatalf("unexpected session ID %s", v)
+	}
+	if !c.client.Resumed() {
+		t.Fatal("session was not resumed")
+	}
+	if v := c.client.Attribute("user"); v != "alice" {
+		t.Fatalf("%s != alice", v)
+	}
+	c.receive(t, s, &Message{Type: "counter.snapshot"})
+	c.close(s)
+
+	// Ensure that the old ID can no longer be used
+	c, _ = dialSession(t, s, id)
+	if c.client.Resumed() {
+		t.Fatal("old session ID was resumed")
+	}
+	c.close(s)
+
+	// Present an unknown session and ensure a new one is created
+	c, v = dialSession(t, s, "unknown")
+	if v == "unknown" || c.client.Resumed() {
+		t.Fatal("unknown session was resumed")
+	}
+	c.close(s)
+}
+
+func TestSessionIdentity(t *testing.T) {
+
+	// Create the server with a session store and an identity that can be
+	// changed between connections
+	var (
+		store    = memory.New(0)
+		identity = "alice"
+		errChan  = make(chan *ClientError, 1)
+		s        = newTestServer(func(h *Herald) {
+			h.SessionStore = store
+			h.SessionIdentity = func(r *http.Request) string {
+				return identity
+			}
+			h.ErrorHandler = func(err *ClientError) {
+				errChan <- err
+			}
+		})
+	)
+	defer s.herald.Close()
+
+	// Connect a client and wait for its session to be saved
+	c, id := dialSession(t, s, "")
+	c.close(s)
+	waitForSession(t, store, id)
+
+	// Present the session with a different identity and ensure that it is
+	// not resumed
+	identity = "mallory"
+	c, _ = dialSession(t, s, id)
+	if c.client.Resumed() {
+		t.Fatal("session was resumed by a different identity")
+	}
+	c.close(s)
+	select {
+	case err := <-errChan:
+		if !errors.Is(err.Err, ErrSessionIdentity) {
+			t.Fatalf("unexpected error: %v", err.Err)
+		}
+	case <-time.After(receiveTimeout):
+		t.Fatal("timeout reached")
+	}
+
+	// Ensure that the original identity can still resume it
+	identity = "alice"
+	c, _ = dialSession(t, s, id)
+	if !c.client.Resumed() {
+		t.Fatal("session was not resumed")
+	}
+	c.close(s)
+}
+
+func TestSessionExpiry(t *testing.T) {
+
+	// Create a store that uses a fake clock and save a session
+	var (
+		clock = newFakeClock()
+		store = memory.New(time.Minute)
+		ctx   = context.Background()
+	)
+	store.Clock = clock
+	if err := store.Save(ctx, "id", []byte("{}")); err != nil {
+		t.Fatal(err)
+	}
+
+	// Ensure that the session expires once the clock passes the TTL
+	if b, _ := store.Load(ctx, "id"); b == nil {
+		t.Fatal("session expired early")
+	}
+	clock.Advance(time.Minute)
+	if b, _ := store.Load(ctx, "id"); b != nil {
+		t.Fatal("session did not expire")
+	}
+}
@@ -0,0 +1,83 @@
+package herald
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// closeFrameTimeout is the maximum amount of time spent writing a close frame.
+const closeFrameTimeout = time.Second
+
+var (
+	// ErrClosed indicates that the Herald is shutting down or has shut down.
+	ErrClosed = errors.New("herald is closed")
+)
+
+// shutdown closes the client's queue so that its write loop writes a close
+// frame and disconnects once the queued messages have been written. If the
+// context is done first, the client is disconnected immediately.
+func (c *Client) shutdown(ctx context.Context) {
+	c.queue.close()
+	go func() {
+		select {
+		case <-ctx.Done():
+			c.conn.Close()
+		case <-c.closedChan:
+		}
+	}()
+}
+
+// writeCloseFrame sends a close frame to the client with the code set by
+// evict() or CloseGoingAway. Errors are ignored since the connection is about
+// to be closed.
+func (c *Client) writeCloseFrame() {
+	c.mutex.Lock()
+	code, text := c.closeCode, c.closeText
+	c.mutex.Unlock()
+	if code == 0 {
+		code = websocket.CloseGoingAway
+	}
+	c.conn.WriteControl(
+		websocket.CloseMessage,
+		websocket.FormatCloseMessage(code, text),
+		time.Now().Add(closeFrameTimeout),
+	)
+}
+
+// Shutdown gracefully shuts down the Herald. The following steps are taken:
+//
+//  1. New clients are rejected and AddClient returns ErrClosed
+//  2. New messages are rejected and Send returns ErrClosed
+//  3. Messages already sent are queued for their clients, followed by the
+//     DrainNotice, if set
+//  4. Each client's queued messages are written to its socket
+//  5. A close frame is sent to each client and it is disconnected
+//
+// If the context is done before all of the queued messages are written, the
+// remaining clients are disconnected immediately and the context's error is
+// returned. Shutdown returns once all clients have been disconnected.
+func (h *Herald) Shutdown(ctx context.Context) error {
+	h.sendMutex.Lock()
+	closing := h.closing
+	h.closing = true
+	h.sendMutex.Unlock()
+	if closing {
+		<-h.closedChan
+		return ErrClosed
+	}
+	h.shutdownCtx = ctx
+	close(h.closeChan)
+	<-h.closedChan
+	h.closeBackplane()
+	return ctx.Err()
+}
+
+// isClosing returns true once shutdown has begun.
+func (h *Herald) isClosing() bool {
+	h.sendMutex.Lock()
+	defer h.sendMutex.Unlock()
+	return h.closing
+}
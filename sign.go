@@ -0,0 +1,102 @@
+package herald
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/json"
+	"strconv"
+)
+
+// Signer signs messages sent to clients so that they can detect tampering by
+// intermediaries. Implementations must be safe for concurrent use.
+type Signer interface {
+
+	// Sign returns the signature for the payload.
+	Sign(payload []byte) []byte
+
+	// Verify determines whether the signature is valid for the payload.
+	Verify(payload, signature []byte) bool
+}
+
+// HMACSigner signs messages with HMAC-SHA256 using a key shared with the
+// clients.
+type HMACSigner struct {
+	Key []byte
+}
+
+// Sign returns the HMAC-SHA256 of the payload.
+func (s *HMACSigner) Sign(payload []byte) []byte {
+	mac := hmac.New(sha256.New, s.Key)
+	mac.Write(payload)
+	return mac.Sum(nil)
+}
+
+// Verify determines whether the signature matches the payload.
+func (s *HMACSigner) Verify(payload, signature []byte) bool {
+	return hmac.Equal(s.Sign(payload), signature)
+}
+
+// Ed25519Signer signs messages with an Ed25519 private key. Clients only need
+// the public key to verify them, so PrivateKey may be omitted when the signer
+// is only used for verification.
+type Ed25519Signer struct {
+	PrivateKey ed25519.PrivateKey
+	PublicKey  ed25519.PublicKey
+}
+
+// Sign returns the Ed25519 signature of the payload.
+func (s *Ed25519Signer) Sign(payload []byte) []byte {
+	return ed25519.Sign(s.PrivateKey, payload)
+}
+
+// Verify determines whether the signature is valid for the payload.
+func (s *Ed25519Signer) Verify(payload, signature []byte) bool {
+	return ed25519.Verify(s.PublicKey, payload, signature)
+}
+
+// signingPayload returns the bytes that are signed for the message: the type,
+// the sequence number, and the compacted data, separated by newlines. If
+// DeliverAt is set, it follows the data on a separate line; if it is zero,
+// the payload ends with the data.
+func signingPayload(m *Message) []byte {
+	b := &bytes.Buffer{}
+	b.WriteString(m.Type)
+	b.WriteByte('\n')
+	b.WriteString(strconv.FormatUint(m.Seq, 10))
+	b.WriteByte('\n')
+	if err := json.Compact(b, m.Data); err != nil {
+		b.Write(m.Data)
+	}
+	if m.DeliverAt != 0 {
+		b.WriteByte('\n')
+		b.WriteString(strconv.FormatInt(m.DeliverAt, 10))
+	}
+	return b.Bytes()
+}
+
+// signMessages returns signed copies of the messages if a signer was
+// provided. This is invoked by the run loop.
+func (h *Herald) signMessages(messages []*Message) []*Message {
+	if h.Signer == nil {
+		return messages
+	}
+	signed := make([]*Message, len(messages))
+	for i, m := range messages {
+		v := *m
+		v.Signature = h.Signer.Sign(signingPayload(m))
+		signed[i] = &v
+	}
+	return signed
+}
+
+// VerifyMessage checks the signature of a message received from a Herald
+// using the same Signer configuration. ErrInvalidSignature is returned if the
+// signature is missing or does not match.
+func VerifyMessage(s Signer, m *Message) error {
+	if len(m.Signature) == 0 || !s.Verify(signingPayload(m), m.Signature) {
+		return ErrInvalidSignature
+	}
+	return nil
+}
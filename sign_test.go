@@ -0,0 +1,81 @@
+package herald
+
+import (
+	"crypto/ed25519"
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+func TestSigners(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range []Signer{
+		&HMACSigner{Key: []byte("key")},
+		&Ed25519Signer{PrivateKey: priv, PublicKey: pub},
+	} {
+		h := &Herald{Signer: s}
+		m := h.signMessages([]*Message{newTestMessage(t, messageType1)})[0]
+		if err := VerifyMessage(s, m); err != nil {
+			t.Fatal(err)
+		}
+		m.Type = messageType2
+		if err := VerifyMessage(s, m); !errors.Is(err, ErrInvalidSignature) {
+			t.Fatalf("%v != %v", err, ErrInvalidSignature)
+		}
+
+		// Ensure that the delivery time is covered by the signature
+		m = newTestMessage(t, messageType1)
+		m.DeliverAt = 1000
+		m = h.signMessages([]*Message{m})[0]
+		if err := VerifyMessage(s, m); err != nil {
+			t.Fatal(err)
+		}
+		m.DeliverAt = 2000
+		if err := VerifyMessage(s, m); !errors.Is(err, ErrInvalidSignature) {
+			t.Fatalf("%v != %v", err, ErrInvalidSignature)
+		}
+	}
+}
+
+func TestSigningPayload(t *testing.T) {
+	m := &Message{Type: messageType1, Seq: 2, Data: json.RawMessage(`{ "a": 1 }`)}
+	if v := string(signingPayload(m)); v != "test1\n2\n{\"a\":1}" {
+		t.Fatalf("unexpected payload: %q", v)
+	}
+	m.DeliverAt = 1000
+	if v := string(signingPayload(m)); v != "test1\n2\n{\"a\":1}\n1000" {
+		t.Fatalf("unexpected payload: %q", v)
+	}
+}
+
+func TestHeraldSigner(t *testing.T) {
+
+	// Create the server with a signer and a client
+	var (
+		signer = &HMACSigner{Key: []byte("key")}
+		s      = newTestServer(func(h *Herald) {
+			h.Signer = signer
+		})
+		c = newTestClient(t, s)
+	)
+	defer s.herald.Close()
+
+	// Send a message with formatted data and ensure the client can verify
+	// it as received
+	m := &Message{
+		Type: messageType1,
+		Data: json.RawMessage(`{ "a": 1 }`),
+	}
+	s.herald.Send(m, nil)
+	v := c.receive(t, s, m)
+	if err := VerifyMessage(signer, v); err != nil {
+		t.Fatal(err)
+	}
+	if m.Signature != nil {
+		t.Fatal("original message was modified")
+	}
+	c.close(s)
+}
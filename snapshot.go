@@ -0,0 +1,69 @@
+package herald
+
+import (
+	"time"
+)
+
+// Snapshot is a consistent view of the Herald's state captured by the run
+// loop. It is not updated after it is returned.
+type Snapshot struct {
+
+	// Time indicates when the snapshot was captured.
+	Time time.Time
+
+	// Clients contains the connected clients in the order they connected.
+	Clients []*Client
+
+	// Subscriptions contains the subscribers of each State, keyed by name.
+	Subscriptions map[string][]*Client
+
+	// Rooms contains the clients in each room, keyed by name.
+	Rooms map[string][]*Client
+}
+
+// snapshot captures the current state. This is invoked by the run loop.
+func (h *Herald) snapshot() *Snapshot {
+	s := &Snapshot{
+		Time:          h.clock().Now(),
+		Clients:       append([]*Client(nil), h.clients...),
+		Subscriptions: make(map[string][]*Client),
+		Rooms:         make(map[string][]*Client),
+	}
+	h.mutex.RLock()
+	states := h.states
+	for room, clients := range h.rooms {
+		s.Rooms[room] = append([]*Client(nil), clients...)
+	}
+	h.mutex.RUnlock()
+	for _, st := range states {
+		st.mutex.Lock()
+		s.Subscriptions[st.name] = append([]*Client(nil), st.subscribers...)
+		st.mutex.Unlock()
+	}
+	return s
+}
+
+// Snapshot captures a consistent view of the Herald's state from within the
+// run loop, ensuring that it does not race with message dispatch. It must not
+// be called from handlers invoked by the run loop. If the Herald has been
+// closed, nil is returned.
+func (h *Herald) Snapshot() *Snapshot {
+	snapshotChan := make(chan *Snapshot, 1)
+	select {
+	case h.snapshotChan <- snapshotChan:
+		return <-snapshotChan
+	case <-h.closedChan:
+		return nil
+	}
+}
+
+// ForEachClient invokes the function for each connected client while the
+// client list is locked. The function must not call other methods on the
+// Herald.
+func (h *Herald) ForEachClient(fn func(client *Client)) {
+	h.mutex.RLock()
+	defer h.mutex.RUnlock()
+	for _, c := range h.clients {
+		fn(c)
+	}
+}
@@ -0,0 +1,136 @@
+package herald
+
+import (
+	"sync"
+)
+
+// SnapshotFunc returns the current value of a state.
+type SnapshotFunc func() (interface{}, error)
+
+// State synchronizes a value with subscribed clients using a snapshot followed
+// by deltas. When a client subscribes, it receives a "<name>.snapshot" message
+// containing the current value, followed by "<name>.delta" messages for each
+// subsequent change. Every message carries a sequence number; the snapshot
+// carries the sequence number of the last delta it includes and deltas are
+// numbered consecutively after it.
+type State struct {
+	herald      *Herald
+	name        string
+	snapshotFn  SnapshotFunc
+	mutex       sync.Mutex
+	seq         uint64
+	subscribers []*Client
+}
+
+// NewState creates a new State with the specified name. The provided function
+// is invoked to obtain the current value for each new subscriber.
+func (h *Herald) NewState(name string, fn SnapshotFunc) *State {
+	s := &State{
+		herald:     h,
+		name:       name,
+		snapshotFn: fn,
+	}
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	h.states = append(h.states, s)
+	return s
+}
+
+// unsubscribeStates removes the client from all states.
+func (h *Herald) unsubscribeStates(c *Client) {
+	h.mutex.RLock()
+	states := h.states
+	h.mutex.RUnlock()
+	for _, s := range states {
+		s.Unsubscribe(c)
+	}
+}
+
+func (s *State) indexOf(c *Client) int {
+	for i, v := range s.subscribers {
+		if v == c {
+			return i
+		}
+	}
+	return -1
+}
+
+// Subscribe sends the current snapshot to the client and begins sending it
+// deltas. Subscribing a client that is already subscribed has no effect. If
+// the Herald's CanSubscribe function rejects the client, its error is
+// returned.
+func (s *State) Subscribe(c *Client) error {
+	if err := s.authorize(c); err != nil {
+		return err
+	}
+	return s.subscribe(c)
+}
+
+// authorize checks whether the client may subscribe to the state.
+func (s *State) authorize(c *Client) error {
+	if fn := s.herald.CanSubscribe; fn != nil {
+		return fn(c, s.name)
+	}
+	return nil
+}
+
+// subscribe subscribes the client without checking CanSubscribe.
+func (s *State) subscribe(c *Client) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.indexOf(c) != -1 {
+		return nil
+	}
+	v, err := s.snapshotFn()
+	if err != nil {
+		return err
+	}
+	m, err := NewMessage(s.name+".snapshot", v)
+	if err != nil {
+		return err
+	}
+	m.Seq = s.seq
+	s.herald.Send(m, []*Client{c})
+	s.subscribers = append(s.subscribers, c)
+	return nil
+}
+
+// Unsubscribe stops sending deltas to the client. Clients are automatically
+// unsubscribed when they disconnect.
+func (s *State) Unsubscribe(c *Client) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if i := s.indexOf(c); i != -1 {
+		s.subscribers = append(s.subscribers[:i], s.subscribers[i+1:]...)
+	}
+}
+
+// Update invokes the provided function and sends the delta it returns to all
+// subscribers. Snapshots are not taken while the function runs, so modifying
+// the underlying value within it guarantees that a new subscriber receives
+// each change exactly once, either in its snapshot or as a delta.
+func (s *State) Update(fn func() (interface{}, error)) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	v, err := fn()
+	if err != nil {
+		return err
+	}
+	m, err := NewMessage(s.name+".delta", v)
+	if err != nil {
+		return err
+	}
+	s.seq++
+	m.Seq = s.seq
+	if len(s.subscribers) != 0 {
+		s.herald.Send(m, append([]*Client(nil), s.subscribers...))
+	}
+	return nil
+}
+
+// Publish sends the delta to all subscribers.
+func (s *State) Publish(delta interface{}) error {
+	return s.Update(func() (interface{}, error) {
+		return delta, nil
+	})
+}
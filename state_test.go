@@ -0,0 +1,92 @@
+package herald
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestState(t *testing.T) {
+
+	// Create the server, a client, and a state containing a counter
+	var (
+		s     = newTestServer()
+		c     = newTestClient(t, s)
+		value = 1
+		st    = s.herald.NewState("counter", func() (interface{}, error) {
+			return value, nil
+		})
+	)
+	defer s.herald.Close()
+
+	// Apply an update before subscribing, which should be in the snapshot
+	if err := st.Update(func() (interface{}, error) {
+		value++
+		return 1, nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Subscribe and verify the snapshot
+	if err := st.Subscribe(c.client); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(s.herald.Snapshot().Subscriptions["counter"], []*Client{c.client}) {
+		t.Fatal("subscription list does not match")
+	}
+	snapshot := c.receive(t, s, &Message{Type: "counter.snapshot"})
+	if snapshot.Seq != 1 {
+		t.Fatalf("%d != 1", snapshot.Seq)
+	}
+	var v int
+	if err := json.Unmarshal(snapshot.Data, &v); err != nil {
+		t.Fatal(err)
+	}
+	if v != 2 {
+		t.Fatalf("%d != 2", v)
+	}
+
+	// Publish a delta and ensure it follows the snapshot
+	if err := st.Publish(1); err != nil {
+		t.Fatal(err)
+	}
+	if delta := c.receive(t, s, &Message{Type: "counter.delta"}); delta.Seq != 2 {
+		t.Fatalf("%d != 2", delta.Seq)
+	}
+	c.close(s)
+}
+
+func TestStateCanSubscribe(t *testing.T) {
+
+	// Create the server with a function that only permits a single state
+	errForbidden := errors.New("forbidden")
+	var (
+		s = newTestServer(func(h *Herald) {
+			h.CanSubscribe = func(c *Client, state string) error {
+				if state != "public" {
+					return errForbidden
+				}
+				return nil
+			}
+		})
+		c       = newTestClient(t, s)
+		fn      = func() (interface{}, error) { return nil, nil }
+		public  = s.herald.NewState("public", fn)
+		private = s.herald.NewState("private", fn)
+	)
+	defer s.herald.Close()
+
+	// Ensure that only the permitted state can be subscribed to
+	if err := public.Subscribe(c.client); err != nil {
+		t.Fatal(err)
+	}
+	c.receive(t, s, &Message{Type: "public.snapshot"})
+	if err := private.Subscribe(c.client); err != errForbidden {
+		t.Fatalf("%v != %v", err, errForbidden)
+	}
+	if v := s.herald.Snapshot().Subscriptions["private"]; len(v) != 0 {
+		t.Fatal("client was subscribed")
+	}
+	c.close(s)
+}
@@ -0,0 +1,74 @@
+package herald
+
+import (
+	"encoding/json"
+	"errors"
+)
+
+// Types of the messages sent by clients to subscribe to and unsubscribe from
+// a State. The data of each message contains "name", the name of the state.
+// Subscriptions are checked with CanSubscribe and an error message is sent to
+// the client if the subscription fails.
+const (
+	SubscribeMessageType   = "herald.subscribe"
+	UnsubscribeMessageType = "herald.unsubscribe"
+)
+
+var (
+	// ErrUnknownState indicates that a client attempted to subscribe to a
+	// state that does not exist.
+	ErrUnknownState = errors.New("unknown state")
+
+	// ErrStateUnavailable is sent to a client that attempted to subscribe to
+	// a state whose snapshot could not be created. The underlying error is
+	// reported with the ErrorSnapshot kind instead.
+	ErrStateUnavailable = errors.New("state unavailable")
+)
+
+type subscribeMessage struct {
+	Name string `json:"name"`
+}
+
+// state returns the state with the specified name or nil if none exists.
+func (h *Herald) state(name string) *State {
+	h.mutex.RLock()
+	defer h.mutex.RUnlock()
+	for _, s := range h.states {
+		if s.name == name {
+			return s
+		}
+	}
+	return nil
+}
+
+// subscribe processes a subscribe or unsubscribe message from a client.
+func (h *Herald) subscribe(m *Message, c *Client) {
+	v := &subscribeMessage{}
+	if err := json.Unmarshal(m.Data, v); err != nil {
+		h.SendError(c, ErrorCodeInvalid, err.Error(), "")
+		return
+	}
+	s := h.state(v.Name)
+	if s == nil {
+		h.SendError(c, ErrorCodeInvalid, ErrUnknownState.Error(), v.Name)
+		return
+	}
+	if m.Type == UnsubscribeMessageType {
+		s.Unsubscribe(c)
+		return
+	}
+	if err := s.authorize(c); err != nil {
+		h.ReportAbuse(c, AbuseAuthFailure)
+		h.SendError(c, ErrorCodeUnauthorized, err.Error(), v.Name)
+		return
+	}
+	if err := s.subscribe(c); err != nil {
+		h.reportError(&ClientError{
+			Kind:    ErrorSnapshot,
+			Client:  c,
+			Message: m,
+			Err:     err,
+		})
+		h.SendError(c, ErrorCodeUnavailable, ErrStateUnavailable.Error(), v.Name)
+	}
+}
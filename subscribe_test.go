@@ -0,0 +1,95 @@
+package herald
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/gorilla/websocket"
+)
+
+var errSnapshot = errors.New("snapshot failed")
+
+func TestHeraldSubscribe(t *testing.T) {
+
+	// Create the server with a function that forbids a single state
+	var (
+		s = newTestServer(func(h *Herald) {
+			h.CanSubscribe = func(c *Client, state string) error {
+				if state == "private" {
+					return errors.New("forbidden")
+				}
+				return nil
+			}
+		})
+		c  = newTestClient(t, s)
+		fn = func() (interface{}, error) { return nil, nil }
+	)
+	defer s.herald.Close()
+	s.herald.NewState("public", fn)
+	s.herald.NewState("private", fn)
+	s.herald.NewState("broken", func() (interface{}, error) {
+		return nil, errSnapshot
+	})
+	errChan := make(chan *ClientError, 1)
+	s.herald.ErrorHandler = func(err *ClientError) {
+		errChan <- err
+	}
+
+	var (
+		write = func(messageType, name string) {
+			m, err := NewMessage(messageType, &subscribeMessage{Name: name})
+			if err != nil {
+				t.Fatal(err)
+			}
+			b, err := json.Marshal(m)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := c.conn.WriteMessage(websocket.TextMessage, b); err != nil {
+				t.Fatal(err)
+			}
+		}
+		expectError = func(code string) *ErrorMessage {
+			m := c.receive(t, s, &Message{Type: ErrorMessageType})
+			e := &ErrorMessage{}
+			if err := json.Unmarshal(m.Data, e); err != nil {
+				t.Fatal(err)
+			}
+			if e.Code != code {
+				t.Fatalf("%s != %s", e.Code, code)
+			}
+			return e
+		}
+	)
+
+	// Subscribe to the permitted state
+	write(SubscribeMessageType, "public")
+	c.receive(t, s, &Message{Type: "public.snapshot"})
+
+	// Ensure that the other state and unknown states are rejected
+	write(SubscribeMessageType, "private")
+	expectError(ErrorCodeUnauthorized)
+	write(SubscribeMessageType, "missing")
+	expectError(ErrorCodeInvalid)
+
+	// Ensure that a failed snapshot is reported without sending its error
+	// to the client
+	write(SubscribeMessageType, "broken")
+	if e := expectError(ErrorCodeUnavailable); e.Message != ErrStateUnavailable.Error() {
+		t.Fatalf("%s != %s", e.Message, ErrStateUnavailable)
+	}
+	if err := <-errChan; err.Kind != ErrorSnapshot || err.Err != errSnapshot {
+		t.Fatalf("unexpected error: %v", err.Err)
+	}
+
+	// Unsubscribe and ensure that the subscription was removed once the
+	// following message is processed
+	write(UnsubscribeMessageType, "public")
+	write(SubscribeMessageType, "missing")
+	expectError(ErrorCodeInvalid)
+	if v := s.herald.Snapshot().Subscriptions["public"]; len(v) != 0 {
+		t.Fatal("client is still subscribed")
+	}
+	c.close(s)
+}
@@ -0,0 +1,148 @@
+package herald
+
+import (
+	"time"
+)
+
+// Throttle limits the rate at which messages are written to a client. Limits
+// with a rate of zero are not enforced.
+//
+// Throttling delays writes but does not enlarge the client's queue, which
+// holds at most ten messages. A client that is sent messages faster than its
+// limits allow is disconnected once its queue fills, just like a client that
+// cannot keep up. Set Coalesce, or give messages coalescing keys, so that
+// newer messages replace older ones instead of accumulating; messages with a
+// sequence number are never coalesced by type, so streams of them must stay
+// within the limits.
+type Throttle struct {
+
+	// MessagesPerSecond limits the number of messages written per second.
+	MessagesPerSecond float64
+
+	// MessageBurst specifies how many messages can be written at once before
+	// the rate limit applies.
+	MessageBurst int
+
+	// BytesPerSecond limits the number of bytes written per second.
+	BytesPerSecond float64
+
+	// ByteBurst specifies how many bytes can be written at once before the
+	// rate limit applies.
+	ByteBurst int
+
+	// Coalesce indicates that messages without a coalescing key are coalesced
+	// by type: when a message is queued, any queued messages of the same type
+	// that have not yet been written are discarded. This keeps a throttled
+	// client from falling behind a full-rate stream. Messages with a sequence
+	// number, such as State deltas, are never coalesced by type since each of
+	// them must be applied in turn.
+	Coalesce bool
+}
+
+// tokenBucket implements a simple token bucket rate limiter. Tokens may be
+// overdrawn, in which case the caller must wait until the balance recovers.
+type tokenBucket struct {
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(rate float64, burst int, now time.Time) *tokenBucket {
+	if rate <= 0 {
+		return nil
+	}
+	if burst < 1 {
+		burst = 1
+	}
+	return &tokenBucket{
+		rate:   rate,
+		burst:  float64(burst),
+		tokens: float64(burst),
+		last:   now,
+	}
+}
+
+// full determines whether the bucket has been replenished to its burst.
+func (b *tokenBucket) full(now time.Time) bool {
+	return b.tokens+now.Sub(b.last).Seconds()*b.rate >= b.burst
+}
+
+// take removes n tokens from the bucket and returns how long the caller must
+// wait before proceeding.
+func (b *tokenBucket) take(n float64, now time.Time) time.Duration {
+	if b == nil {
+		return 0
+	}
+	b.tokens += now.Sub(b.last).Seconds() * b.rate
+	if b.tokens > b.burst {
+		b.tokens = b.burst
+	}
+	b.last = now
+	b.tokens -= n
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / b.rate * float64(time.Second))
+}
+
+// allow removes n tokens from the bucket if they are available and returns
+// true; otherwise the bucket is left unchanged and the function returns false
+// along with how long to wait before the tokens are available.
+func (b *tokenBucket) allow(n float64, now time.Time) (bool, time.Duration) {
+	b.tokens += now.Sub(b.last).Seconds() * b.rate
+	if b.tokens > b.burst {
+		b.tokens = b.burst
+	}
+	b.last = now
+	if b.tokens < n {
+		return false, time.Duration((n - b.tokens) / b.rate * float64(time.Second))
+	}
+	b.tokens -= n
+	return true, 0
+}
+
+// SetThrottle sets the rate limits for messages written to the client. Passing
+// nil removes the limits.
+func (c *Client) SetThrottle(t *Throttle) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.throttle = t
+	c.messageBucket = nil
+	c.byteBucket = nil
+	if t != nil {
+		now := c.herald.clock().Now()
+		c.messageBucket = newTokenBucket(t.MessagesPerSecond, t.MessageBurst, now)
+		c.byteBucket = newTokenBucket(t.BytesPerSecond, t.ByteBurst, now)
+	}
+}
+
+// coalesceKey returns the key used to coalesce the message in the client's
+// queue or an empty string if the message should not be coalesced.
+func (c *Client) coalesceKey(m *Message) string {
+	if m.Key != "" {
+		return m.Key
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.throttle != nil && c.throttle.Coalesce && m.Seq == 0 {
+		return m.Type
+	}
+	return ""
+}
+
+// throttleDelay returns how long to wait before writing a message of the
+// specified size to the client.
+func (c *Client) throttleDelay(size int) time.Duration {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	var (
+		now = c.herald.clock().Now()
+		d1  = c.messageBucket.take(1, now)
+		d2  = c.byteBucket.take(float64(size), now)
+	)
+	if d2 > d1 {
+		return d2
+	}
+	return d1
+}
@@ -0,0 +1,60 @@
+package herald
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTokenBucket(t *testing.T) {
+	var (
+		now = time.Now()
+		b   = newTokenBucket(10, 2, now)
+	)
+	for i, d := range []time.Duration{
+		0,
+		0,
+		100 * time.Millisecond,
+		200 * time.Millisecond,
+	} {
+		if v := b.take(1, now); v != d {
+			t.Fatalf("%d: %s != %s", i, v, d)
+		}
+	}
+	if v := b.take(1, now.Add(time.Second)); v != 0 {
+		t.Fatalf("%s != 0", v)
+	}
+}
+
+func TestQueueCoalesce(t *testing.T) {
+	var (
+		q  = newQueue(nil)
+		r  = newReceipt()
+		m1 = &Message{Type: messageType1}
+		m2 = &Message{Type: messageType1}
+	)
+	r.start(2)
+	q.push([]*outgoing{{message: m1, receipt: r}}, messageType1)
+	q.push([]*outgoing{{message: m2, receipt: r}}, messageType1)
+	if o := q.pop(); o.message != m2 {
+		t.Fatal("message was not coalesced")
+	}
+	if len(r.Failed()) != 1 {
+		t.Fatal("superseded message was not completed")
+	}
+}
+
+func TestCoalesceKey(t *testing.T) {
+	c := &Client{throttle: &Throttle{Coalesce: true}}
+	for _, v := range []struct {
+		message *Message
+		key     string
+	}{
+		{&Message{Type: messageType1}, messageType1},
+		{&Message{Type: messageType1, Key: "key"}, "key"},
+		{&Message{Type: messageType1, Seq: 1}, ""},
+	} {
+		if k := c.coalesceKey(v.message); k != v.key {
+			t.Fatalf("%s != %s", k, v.key)
+		}
+	}
+}
@@ -0,0 +1,127 @@
+package herald
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	defaultTicketParam = "ticket"
+	defaultTicketTTL   = 30 * time.Second
+)
+
+var (
+	// ErrInvalidTicket indicates that a connection ticket is missing, has
+	// expired, or was already redeemed.
+	ErrInvalidTicket = errors.New("invalid ticket")
+)
+
+type ticket struct {
+	data    interface{}
+	expires time.Time
+}
+
+// TicketIssuer issues short-lived, single-use connection tickets. A ticket is
+// requested from an authenticated HTTP endpoint (typically by wrapping the
+// issuer in the application's authentication middleware) and then included
+// in the query string of the WebSocket URL. Since browsers do not enforce
+// same-origin restrictions on WebSocket connections, this prevents other
+// sites from connecting on behalf of the user with their cookies.
+type TicketIssuer struct {
+
+	// DataFunc returns the data associated with the ticket issued for the
+	// request, which is returned by Redeem() when the ticket is used. If an
+	// error is returned, the request is rejected with 401 Unauthorized. If
+	// nil, tickets have no data.
+	DataFunc func(r *http.Request) (interface{}, error)
+
+	// TTL specifies how long a ticket remains valid after being issued. If
+	// zero, thirty seconds is used.
+	TTL time.Duration
+
+	// Param is the name of the query parameter containing the ticket. If
+	// empty, "ticket" is used.
+	Param string
+
+	// Clock provides the current time. If nil, the system clock is used.
+	Clock Clock
+
+	mutex   sync.Mutex
+	tickets map[string]*ticket
+}
+
+func (t *TicketIssuer) ttl() time.Duration {
+	if t.TTL == 0 {
+		return defaultTicketTTL
+	}
+	return t.TTL
+}
+
+// Issue creates a new ticket with the specified data.
+func (t *TicketIssuer) Issue(data interface{}) string {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	now := clockOrSystem(t.Clock).Now()
+	if t.tickets == nil {
+		t.tickets = make(map[string]*ticket)
+	}
+	for id, v := range t.tickets {
+		if now.After(v.expires) {
+			delete(t.tickets, id)
+		}
+	}
+	id := newID()
+	t.tickets[id] = &ticket{
+		data:    data,
+		expires: now.Add(t.ttl()),
+	}
+	return id
+}
+
+// Redeem validates the ticket in the request's query string and returns its
+// data. Each ticket can only be redeemed once. This should be called before
+// AddClient() so that the data can be passed to it.
+func (t *TicketIssuer) Redeem(r *http.Request) (interface{}, error) {
+	param := t.Param
+	if param == "" {
+		param = defaultTicketParam
+	}
+	id := r.URL.Query().Get(param)
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	v, ok := t.tickets[id]
+	if !ok {
+		return nil, ErrInvalidTicket
+	}
+	delete(t.tickets, id)
+	if clockOrSystem(t.Clock).Now().After(v.expires) {
+		return nil, ErrInvalidTicket
+	}
+	return v.data, nil
+}
+
+// ServeHTTP issues a ticket in response to a POST request. The response body
+// contains a JSON object with the ticket in the "ticket" field.
+func (t *TicketIssuer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	var data interface{}
+	if t.DataFunc != nil {
+		v, err := t.DataFunc(r)
+		if err != nil {
+			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			return
+		}
+		data = v
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(map[string]string{
+		"ticket": t.Issue(data),
+	})
+}
@@ -0,0 +1,45 @@
+package herald
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestTicketIssuer(t *testing.T) {
+
+	// Create an issuer that associates the user with each ticket
+	i := &TicketIssuer{
+		DataFunc: func(r *http.Request) (interface{}, error) {
+			return r.Header.Get("X-User"), nil
+		},
+	}
+
+	// Request a ticket
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodPost, "/", nil)
+	r.Header.Set("X-User", clientData)
+	i.ServeHTTP(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("%d != %d", w.Code, http.StatusOK)
+	}
+	v := map[string]string{}
+	if err := json.NewDecoder(w.Body).Decode(&v); err != nil {
+		t.Fatal(err)
+	}
+
+	// Ensure the ticket can be redeemed exactly once
+	r = httptest.NewRequest(http.MethodGet, "/?ticket="+v["ticket"], nil)
+	data, err := i.Redeem(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data != clientData {
+		t.Fatalf("%v != %s", data, clientData)
+	}
+	if _, err := i.Redeem(r); !errors.Is(err, ErrInvalidTicket) {
+		t.Fatalf("%v != %v", err, ErrInvalidTicket)
+	}
+}
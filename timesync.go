@@ -0,0 +1,69 @@
+package herald
+
+import (
+	"encoding/json"
+	"errors"
+	"time"
+)
+
+// TimeSyncMessageType is the type of the messages exchanged by clients to
+// estimate the offset between their clock and the server's. The client sends
+// "t0", the time at which it sent the request, and the server replies with a
+// message of the same type containing "t0" along with "received" and "sent",
+// the times at which the server received the request and queued the reply.
+// All times are in milliseconds since the Unix epoch. If the reply arrives at
+// t3, the client's clock is behind the server's by approximately
+// ((received - t0) + (sent - t3)) / 2.
+//
+// To prevent replies from being replayed, t0 must be greater than in the
+// previous request from the same client; other requests are rejected with an
+// error message. The reply is signed by Signer like any other message.
+const TimeSyncMessageType = "herald.timesync"
+
+var (
+	// ErrTimeSyncReplayed indicates that a client sent a time sync request
+	// whose t0 was not greater than that of its previous request.
+	ErrTimeSyncReplayed = errors.New("time sync request replayed")
+)
+
+type timeSyncMessage struct {
+	T0       int64 `json:"t0"`
+	Received int64 `json:"received,omitempty"`
+	Sent     int64 `json:"sent,omitempty"`
+}
+
+// unixMillis returns the time in milliseconds since the Unix epoch.
+func unixMillis(t time.Time) int64 {
+	return t.UnixNano() / int64(time.Millisecond)
+}
+
+// timeSync replies to a time sync request from a client.
+func (h *Herald) timeSync(m *Message, c *Client) {
+	received := unixMillis(h.clock().Now())
+	v := &timeSyncMessage{}
+	if err := json.Unmarshal(m.Data, v); err != nil {
+		h.SendError(c, ErrorCodeInvalid, err.Error(), "")
+		return
+	}
+	if v.T0 <= c.lastTimeSync {
+		h.SendError(c, ErrorCodeInvalid, ErrTimeSyncReplayed.Error(), "")
+		return
+	}
+	c.lastTimeSync = v.T0
+	v.Received = received
+	v.Sent = unixMillis(h.clock().Now())
+	reply, err := NewMessage(TimeSyncMessageType, v)
+	if err != nil {
+		h.SendError(c, ErrorCodeInvalid, err.Error(), "")
+		return
+	}
+	h.Send(reply, []*Client{c})
+}
+
+// BroadcastAt sends the message to all clients with DeliverAt set to the
+// specified duration from now, giving each client time to receive it before
+// presenting it. ErrClosed is returned if the Herald is shutting down.
+func (h *Herald) BroadcastAt(message *Message, d time.Duration) error {
+	message.DeliverAt = unixMillis(h.clock().Now().Add(d))
+	return h.Send(message, nil)
+}
@@ -0,0 +1,74 @@
+package herald
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestHeraldTimeSync(t *testing.T) {
+
+	// Create the server with a clock fixed at a known time
+	clock := newFakeClock()
+	clock.now = time.Unix(1000, 0)
+	s := newTestServer(func(h *Herald) {
+		h.Clock = clock
+		h.TimeSync = true
+	})
+	defer s.herald.Close()
+	c := newTestClient(t, s)
+
+	// Utility function for sending a request
+	request := func(t0 int64) {
+		m, err := NewMessage(TimeSyncMessageType, &timeSyncMessage{T0: t0})
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := json.Marshal(m)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := c.conn.WriteMessage(websocket.TextMessage, b); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Send a request and verify the reply
+	request(5)
+	reply := c.receive(t, s, &Message{Type: TimeSyncMessageType})
+	v := &timeSyncMessage{}
+	if err := json.Unmarshal(reply.Data, v); err != nil {
+		t.Fatal(err)
+	}
+	if *v != (timeSyncMessage{T0: 5, Received: 1000000, Sent: 1000000}) {
+		t.Fatalf("unexpected reply: %+v", v)
+	}
+
+	// Replay the request and ensure that it is rejected
+	request(5)
+	c.receive(t, s, &Message{Type: ErrorMessageType})
+	c.close(s)
+}
+
+func TestHeraldBroadcastAt(t *testing.T) {
+
+	// Create the server with a clock fixed at a known time
+	clock := newFakeClock()
+	clock.now = time.Unix(1000, 0)
+	s := newTestServer(func(h *Herald) {
+		h.Clock = clock
+	})
+	defer s.herald.Close()
+	c := newTestClient(t, s)
+
+	// Broadcast a message and ensure that the client receives the time
+	if err := s.herald.BroadcastAt(newTestMessage(t, messageType1), 3*time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if m := c.receive(t, s, &Message{Type: messageType1}); m.DeliverAt != 1003000 {
+		t.Fatalf("%d != 1003000", m.DeliverAt)
+	}
+	c.close(s)
+}
@@ -0,0 +1,62 @@
+package herald
+
+// TransformFunc modifies a message before it is sent, for example to enrich
+// it with server data or to strip internal fields. It may modify and return
+// the message it receives or return a new one. If nil is returned, the
+// message is discarded.
+type TransformFunc func(message *Message) (*Message, error)
+
+// AddTransform registers a transformation for messages of the specified
+// type. Transformations run in the order they were registered, once for each
+// message sent, before it is delivered to any clients or published to the
+// backplane. If a transformation returns an error, the message is discarded
+// and the error is reported to ErrorHandler. This method must be called
+// before Start().
+func (h *Herald) AddTransform(messageType string, fn TransformFunc) {
+	if h.transforms == nil {
+		h.transforms = make(map[string][]TransformFunc)
+	}
+	h.transforms[messageType] = append(h.transforms[messageType], fn)
+}
+
+// transformMessages applies the registered transformations to the messages.
+// The messages themselves are not modified since the caller may still hold
+// them.
+func (h *Herald) transformMessages(messages []*Message) []*Message {
+	if h.transforms == nil {
+		return messages
+	}
+	transformed := make([]*Message, 0, len(messages))
+	for _, m := range messages {
+		fns := h.transforms[m.Type]
+		if len(fns) == 0 {
+			transformed = append(transformed, m)
+			continue
+		}
+		v := *m
+		if t := h.transform(&v, fns); t != nil {
+			transformed = append(transformed, t)
+		}
+	}
+	return transformed
+}
+
+// transform applies the functions to the message in order.
+func (h *Herald) transform(m *Message, fns []TransformFunc) *Message {
+	for _, fn := range fns {
+		t, err := fn(m)
+		if err != nil {
+			h.reportError(&ClientError{
+				Kind:    ErrorTransform,
+				Message: m,
+				Err:     err,
+			})
+			return nil
+		}
+		if t == nil {
+			return nil
+		}
+		m = t
+	}
+	return m
+}
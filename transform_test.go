@@ -0,0 +1,45 @@
+package herald
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestHeraldTransform(t *testing.T) {
+
+	// Create the server with a transformation that enriches one type and
+	// another that discards a different type
+	s := newTestServer(func(h *Herald) {
+		h.AddTransform(messageType1, func(m *Message) (*Message, error) {
+			m.Data = json.RawMessage(`"enriched"`)
+			return m, nil
+		})
+		h.AddTransform(messageType2, func(m *Message) (*Message, error) {
+			return nil, nil
+		})
+	})
+	defer s.herald.Close()
+	c := newTestClient(t, s)
+
+	// Send both types and ensure that only the transformed message arrives
+	var (
+		m1 = newTestMessage(t, messageType1)
+		m2 = newTestMessage(t, messageType2)
+	)
+	if err := s.herald.Send(m2, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.herald.Send(m1, nil); err != nil {
+		t.Fatal(err)
+	}
+	m := c.receive(t, s, &Message{Type: messageType1})
+	if string(m.Data) != `"enriched"` {
+		t.Fatalf("%s != \"enriched\"", m.Data)
+	}
+
+	// Ensure that the original message was not modified
+	if string(m1.Data) != "null" {
+		t.Fatalf("%s != null", m1.Data)
+	}
+	c.close(s)
+}
@@ -0,0 +1,89 @@
+package herald
+
+// HandlerFunc processes a message received from a client.
+type HandlerFunc func(message *Message, client *Client) error
+
+// UnknownTypePolicy determines how messages without a registered handler are
+// processed.
+type UnknownTypePolicy int
+
+const (
+
+	// UnknownFallback passes the message to MessageHandlerWithError or
+	// MessageHandler.
+	UnknownFallback UnknownTypePolicy = iota
+
+	// UnknownDrop silently discards the message.
+	UnknownDrop
+
+	// UnknownReject discards the message and sends an error message to the
+	// client that sent it.
+	UnknownReject
+
+	// UnknownDisconnect discards the message and disconnects the client that
+	// sent it.
+	UnknownDisconnect
+)
+
+// Handle registers a handler for messages of the specified type. Messages
+// with a registered type are passed to their handler instead of
+// MessageHandlerWithError or MessageHandler and UnknownTypePolicy determines
+// how messages of other types are processed. This method must be called
+// before Start().
+func (h *Herald) Handle(messageType string, fn HandlerFunc) {
+	if h.handlers == nil {
+		h.handlers = make(map[string]HandlerFunc)
+	}
+	h.handlers[messageType] = fn
+}
+
+// fallbackHandler returns the handler for messages without a registered
+// handler or nil if there is none.
+func (h *Herald) fallbackHandler() HandlerFunc {
+	if h.MessageHandlerWithError != nil {
+		return h.MessageHandlerWithError
+	}
+	if fn := h.MessageHandler; fn != nil {
+		return func(m *Message, c *Client) error {
+			fn(m, c)
+			return nil
+		}
+	}
+	return nil
+}
+
+// handlerFor returns the handler for the message, applying UnknownTypePolicy
+// if no handler is registered for its type. Nil is returned if the message
+// should not be processed.
+func (h *Herald) handlerFor(m *Message, c *Client) HandlerFunc {
+	if h.handlers == nil {
+		return h.fallbackHandler()
+	}
+	if fn, ok := h.handlers[m.Type]; ok {
+		return fn
+	}
+	switch h.UnknownTypePolicy {
+	case UnknownDrop:
+	case UnknownReject:
+		h.reportUnknownType(m, c)
+		h.SendError(c, ErrorCodeUnknownType, ErrUnknownType.Error(), "")
+	case UnknownDisconnect:
+		h.reportUnknownType(m, c)
+		c.conn.Close()
+	default:
+		return h.fallbackHandler()
+	}
+	return nil
+}
+
+// reportUnknownType reports a message that was rejected because of its type
+// and passes it to DeadLetterHandler.
+func (h *Herald) reportUnknownType(m *Message, c *Client) {
+	h.reportError(&ClientError{
+		Kind:    ErrorProtocol,
+		Client:  c,
+		Message: m,
+		Err:     ErrUnknownType,
+	})
+	h.deadLetter(m, c, 0, ErrUnknownType)
+}
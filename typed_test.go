@@ -0,0 +1,56 @@
+package herald
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestHeraldHandle(t *testing.T) {
+
+	// Create the server with a handler for a single type that rejects all
+	// other types
+	var s *testServer
+	s = newTestServer(func(h *Herald) {
+		h.UnknownTypePolicy = UnknownReject
+		h.Handle(messageType1, func(m *Message, c *Client) error {
+			s.receivedWG.Done()
+			return nil
+		})
+	})
+	defer s.herald.Close()
+	deadLetterChan := make(chan *DeadLetter, 1)
+	s.herald.DeadLetterHandler = func(d *DeadLetter) {
+		deadLetterChan <- d
+	}
+	c := newTestClient(t, s)
+
+	// Ensure that the registered type is handled
+	c.send(t, s, newTestMessage(t, messageType1))
+
+	// Ensure that an unknown type is rejected with an error message and
+	// dead-lettered
+	b, err := json.Marshal(newTestMessage(t, messageType2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.conn.WriteMessage(websocket.TextMessage, b); err != nil {
+		t.Fatal(err)
+	}
+	m := c.receive(t, s, &Message{Type: ErrorMessageType})
+	e := &ErrorMessage{}
+	if err := json.Unmarshal(m.Data, e); err != nil {
+		t.Fatal(err)
+	}
+	if e.Code != ErrorCodeUnknownType {
+		t.Fatalf("%s != %s", e.Code, ErrorCodeUnknownType)
+	}
+	d := <-deadLetterChan
+	if d.Message.Type != messageType2 || d.Attempts != 0 || d.Err != ErrUnknownType {
+		t.Fatalf("unexpected dead letter: %+v", d)
+	}
+
+	// Close the client
+	c.close(s)
+}
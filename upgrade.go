@@ -0,0 +1,157 @@
+package herald
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+var (
+	// ErrBadOrigin indicates that a connection was rejected because its
+	// origin was not allowed.
+	ErrBadOrigin = errors.New("request origin not allowed")
+)
+
+// UpgradeFailure indicates why a connection could not be established.
+type UpgradeFailure int
+
+const (
+
+	// UpgradeClosed indicates that the Herald was shutting down.
+	UpgradeClosed UpgradeFailure = iota
+
+	// UpgradeMaintenance indicates that maintenance mode was enabled.
+	UpgradeMaintenance
+
+	// UpgradeRateLimited indicates that AcceptRate was exceeded.
+	UpgradeRateLimited
+
+	// UpgradeRejected indicates that a function registered with
+	// UseConnect() rejected the connection, typically because the client
+	// could not be authenticated.
+	UpgradeRejected
+
+	// UpgradeBadOrigin indicates that the origin of the request was not
+	// allowed.
+	UpgradeBadOrigin
+
+	// UpgradeBadRequest indicates that the request was not a valid
+	// WebSocket upgrade request.
+	UpgradeBadRequest
+
+	// UpgradeHandshakeTimeout indicates that the client did not send its
+	// first message within HandshakeTimeout.
+	UpgradeHandshakeTimeout
+
+	// UpgradeHandshakeInvalid indicates that the first message sent by the
+	// client did not have the type specified by HandshakeType.
+	UpgradeHandshakeInvalid
+
+	// UpgradeBanned indicates that the abuse score of the client reached
+	// AbusePolicy.BanScore.
+	UpgradeBanned
+)
+
+// String returns a human-readable name for the failure.
+func (f UpgradeFailure) String() string {
+	switch f {
+	case UpgradeClosed:
+		return "closed"
+	case UpgradeMaintenance:
+		return "maintenance"
+	case UpgradeRateLimited:
+		return "rate_limited"
+	case UpgradeRejected:
+		return "rejected"
+	case UpgradeBadOrigin:
+		return "bad_origin"
+	case UpgradeBadRequest:
+		return "bad_request"
+	case UpgradeHandshakeTimeout:
+		return "handshake_timeout"
+	case UpgradeHandshakeInvalid:
+		return "handshake_invalid"
+	case UpgradeBanned:
+		return "banned"
+	default:
+		return "unknown"
+	}
+}
+
+// admit runs the checks for a new connection before it is upgraded. If the
+// connection is rejected, the response is written and the reason returned.
+func (h *Herald) admit(w http.ResponseWriter, r *http.Request) (UpgradeFailure, error) {
+	if h.isClosing() {
+		h.reject(w, r, http.StatusServiceUnavailable, ErrClosed)
+		return UpgradeClosed, ErrClosed
+	}
+	if err := h.checkMaintenance(w, r); err != nil {
+		return UpgradeMaintenance, err
+	}
+	if err := h.checkBanned(w, r); err != nil {
+		return UpgradeBanned, err
+	}
+	if err := h.waitAccept(w, r); err != nil {
+		return UpgradeRateLimited, err
+	}
+	if !h.checkOrigin(r) {
+		h.reject(w, r, http.StatusForbidden, ErrBadOrigin)
+		return UpgradeBadOrigin, ErrBadOrigin
+	}
+	if err := h.checkConnect(w, r); err != nil {
+		h.reportRequestAbuse(r, AbuseAuthFailure)
+		return UpgradeRejected, err
+	}
+	return 0, nil
+}
+
+// checkOrigin applies the function provided to SetCheckOrigin() or, if none
+// was provided, ensures that the Origin header, if present, matches the Host
+// header.
+func (h *Herald) checkOrigin(r *http.Request) bool {
+	if fn := h.upgrader.CheckOrigin; fn != nil {
+		return fn(r)
+	}
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	u, err := url.Parse(origin)
+	if err != nil {
+		return false
+	}
+	return strings.EqualFold(u.Host, r.Host)
+}
+
+// upgradeFailed counts the failure and passes it to UpgradeFailureHandler.
+func (h *Herald) upgradeFailed(r *http.Request, failure UpgradeFailure, err error) {
+	h.failureMutex.Lock()
+	if h.failures == nil {
+		h.failures = make(map[UpgradeFailure]uint64)
+	}
+	h.failures[failure]++
+	h.failureMutex.Unlock()
+	if h.UpgradeFailureHandler != nil {
+		h.UpgradeFailureHandler(r, failure, err)
+	}
+}
+
+// isTimeout determines whether the error was caused by a deadline passing.
+func isTimeout(err error) bool {
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+// UpgradeFailures returns the number of connections that could not be
+// established since the Herald was created, by reason.
+func (h *Herald) UpgradeFailures() map[UpgradeFailure]uint64 {
+	h.failureMutex.Lock()
+	defer h.failureMutex.Unlock()
+	failures := make(map[UpgradeFailure]uint64, len(h.failures))
+	for k, v := range h.failures {
+		failures[k] = v
+	}
+	return failures
+}
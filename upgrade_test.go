@@ -0,0 +1,72 @@
+package herald
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestHeraldUpgradeFailures(t *testing.T) {
+
+	// Create the server with a connect function that rejects requests
+	// without a token and record each failure
+	var (
+		s = newTestServer(func(h *Herald) {
+			h.UseConnect(func(r *http.Request) error {
+				if r.URL.Query().Get("token") == "" {
+					return &ConnectError{StatusCode: http.StatusUnauthorized}
+				}
+				return nil
+			})
+		})
+		failureChan = make(chan UpgradeFailure, 3)
+		server      = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			s.herald.AddClient(w, r, clientData)
+		}))
+		addr = strings.Replace(server.URL, "http", "ws", 1)
+	)
+	defer s.herald.Close()
+	defer server.Close()
+	s.herald.UpgradeFailureHandler = func(r *http.Request, f UpgradeFailure, err error) {
+		failureChan <- f
+	}
+
+	// Make a request from a different origin, a request without a token,
+	// and a request that is not a WebSocket upgrade
+	header := http.Header{}
+	header.Set("Origin", "https://example.com")
+	if _, _, err := websocket.DefaultDialer.Dial(addr+"?token=x", header); err == nil {
+		t.Fatal("error expected")
+	}
+	if _, _, err := websocket.DefaultDialer.Dial(addr, nil); err == nil {
+		t.Fatal("error expected")
+	}
+	resp, err := http.Get(server.URL + "?token=x")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	// Ensure that each failure was reported and counted
+	var (
+		expected = map[UpgradeFailure]uint64{
+			UpgradeBadOrigin:  1,
+			UpgradeRejected:   1,
+			UpgradeBadRequest: 1,
+		}
+		reported = map[UpgradeFailure]uint64{}
+	)
+	for i := 0; i < 3; i++ {
+		reported[<-failureChan]++
+	}
+	if !reflect.DeepEqual(reported, expected) {
+		t.Fatalf("unexpected reported failures: %v", reported)
+	}
+	if v := s.herald.UpgradeFailures(); !reflect.DeepEqual(v, expected) {
+		t.Fatalf("unexpected failures: %v", v)
+	}
+}
@@ -0,0 +1,56 @@
+package herald
+
+import (
+	"encoding/json"
+)
+
+// VisibilityMessageType is the type of the message sent by clients to report
+// whether they are in the background, such as a browser tab that is hidden.
+// The data of the message contains "hidden", which is true when the client
+// is in the background and false when it returns to the foreground.
+const VisibilityMessageType = "herald.visibility"
+
+type visibilityMessage struct {
+	Hidden bool `json:"hidden"`
+}
+
+// visibility processes a visibility message from a client.
+func (h *Herald) visibility(m *Message, c *Client) {
+	v := &visibilityMessage{}
+	if err := json.Unmarshal(m.Data, v); err != nil {
+		h.SendError(c, ErrorCodeInvalid, err.Error(), "")
+		return
+	}
+	c.setBackground(v.Hidden)
+}
+
+// setBackground applies BackgroundThrottle to the client when it moves to
+// the background and restores its previous throttle when it returns. Clients
+// throttled by AbusePolicy keep that throttle regardless of visibility.
+func (c *Client) setBackground(background bool) {
+	c.mutex.Lock()
+	if c.background == background {
+		c.mutex.Unlock()
+		return
+	}
+	c.background = background
+	if c.abuseThrottled {
+		c.mutex.Unlock()
+		return
+	}
+	t := c.foregroundThrottle
+	if background {
+		c.foregroundThrottle = c.throttle
+		t = c.herald.BackgroundThrottle
+	}
+	c.mutex.Unlock()
+	c.SetThrottle(t)
+}
+
+// Background returns true if the client reported that it is in the
+// background and is being throttled with BackgroundThrottle.
+func (c *Client) Background() bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.background
+}
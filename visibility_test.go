@@ -0,0 +1,84 @@
+package herald
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/gorilla/websocket"
+)
+
+// setHidden reports the visibility of the client, followed by another message
+// to ensure that the report has been processed.
+func setHidden(t *testing.T, s *testServer, c *testClient, hidden bool) {
+	m, err := NewMessage(VisibilityMessageType, &visibilityMessage{Hidden: hidden})
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := json.Marshal(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.conn.WriteMessage(websocket.TextMessage, b); err != nil {
+		t.Fatal(err)
+	}
+	c.send(t, s, newTestMessage(t, messageType1))
+}
+
+func TestHeraldVisibility(t *testing.T) {
+
+	// Create the server with a throttle for background clients
+	s := newTestServer(func(h *Herald) {
+		h.BackgroundThrottle = &Throttle{Coalesce: true}
+	})
+	defer s.herald.Close()
+	c := newTestClient(t, s)
+
+	// Ensure the throttle is applied in the background and removed in the
+	// foreground
+	m := newTestMessage(t, messageType1)
+	setHidden(t, s, c, true)
+	if !c.client.Background() || c.client.coalesceKey(m) != messageType1 {
+		t.Fatal("background throttle not applied")
+	}
+	setHidden(t, s, c, false)
+	if c.client.Background() || c.client.coalesceKey(m) != "" {
+		t.Fatal("background throttle not removed")
+	}
+	c.close(s)
+}
+
+func TestHeraldVisibilityAbuseThrottle(t *testing.T) {
+
+	// Create the server with a throttle for background clients and a
+	// throttle for abusive clients
+	abuse := &Throttle{MessagesPerSecond: 1}
+	s := newTestServer(func(h *Herald) {
+		h.BackgroundThrottle = &Throttle{Coalesce: true}
+		h.AbusePolicy = &AbusePolicy{
+			ThrottleScore: 1,
+			Throttle:      abuse,
+		}
+	})
+	defer s.herald.Close()
+	c := newTestClient(t, s)
+
+	// Ensure the abuse throttle applied in the background is kept when the
+	// client returns to the foreground and when it moves back and forth
+	throttle := func() *Throttle {
+		c.client.mutex.Lock()
+		defer c.client.mutex.Unlock()
+		return c.client.throttle
+	}
+	setHidden(t, s, c, true)
+	s.herald.ReportAbuse(c.client, AbuseRateLimited)
+	setHidden(t, s, c, false)
+	if throttle() != abuse {
+		t.Fatal("abuse throttle not kept")
+	}
+	setHidden(t, s, c, true)
+	setHidden(t, s, c, false)
+	if throttle() != abuse {
+		t.Fatal("abuse throttle not kept")
+	}
+	c.close(s)
+}